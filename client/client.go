@@ -5,18 +5,25 @@ import (
 	"context"
 	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/apernet/quic-go"
+	"github.com/apernet/quic-go/logging"
 	"github.com/apernet/quic-go/qlog"
 	"github.com/dustin/go-humanize"
 	"github.com/urfave/cli/v2"
 	"io"
 	"log"
+	"math/rand"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"qperf-go/common"
+	"qperf-go/internal/congestion"
+	"qperf-go/internal/congestion/bbr"
+	"qperf-go/internal/netem"
+	"slices"
 	"sync"
 	"time"
 
@@ -38,27 +45,72 @@ type Client struct {
 	reportInterval time.Duration
 	logger         common.Logger
 	StatesHistory  []*States
+	// index of the connection if multiple connections are used
+	connection int
+	parameters common.TestParameters
+	streams    []*clientStream
+	// number of streams per direction
+	parallelStreams int
+	directions      []string
+	firstByte       chan struct{}
+	firstByteOnce   sync.Once
+	endTime         time.Time
+	// set if BBR is used for sending
+	bbrState bbr.StateProvider
+	// shares the emulated link and the migrating socket between all connections, nil to dial without both
+	transport *quic.Transport
+	// the socket of transport if it is migrated
+	migratingConn   *migratingConn
+	receivedSamples []receivedSample
+	// the server accepted 0-RTT
+	used0RTT bool
 }
 
 type States struct {
-	RateBits float64
-	Bytes    uint64
-	Second   int
-	Packets  uint64
+	RateBits   float64
+	Bytes      uint64
+	Second     int
+	Packets    uint64
+	Direction  string
+	Connection int
+	// QUIC packets of the connection in this second
+	SentPackets       uint64
+	LostPackets       uint64
+	WireBytesSent     uint64
+	WireBytesReceived uint64
+}
+
+// FlowResult is a single transfer of the flow completion time measurement.
+type FlowResult struct {
+	Flow int
+	Size uint64
+	// in seconds, measured from the start of the connection
+	CompletionTime  float64
+	TimeToFirstByte float64
+	Used0RTT        bool
 }
 
 // Run client.
 // if proxyAddr is nil, no proxy is used.
-func Run(addr net.UDPAddr, timeToFirstByteOnly bool, printRaw bool, createQLog bool, migrateAfter time.Duration, proxyAddr *net.UDPAddr, probeTime time.Duration, reportInterval time.Duration, tlsServerCertFile string, tlsProxyCertFile string, initialCongestionWindow uint32, initialReceiveWindow uint64, maxReceiveWindow uint64, use0RTT bool, useProxy0RTT, allowEarlyHandover bool, useXse bool, logPrefix string, qlogPrefix string, http3enabled bool, quiet bool, args cli.Args) {
+// if upload is true, the client sends data and the server reports the received bytes.
+// if bidirectional is true, data is sent in both directions at the same time.
+// parallelStreams is the number of streams opened per direction.
+// parallelConnections is the number of independent QUIC connections.
+// blockSize is the size of a single write on a data stream.
+// if byteLimit is not 0, the server sends that many bytes per stream and the flow completion time is reported instead of running probeTime.
+// cc is the congestion control requested from the server, empty for the server default.
+// rxBandwidth is the receive bandwidth announced to the server in bytes per second, used as brutal rate, 0 for none.
+// if netemConf is not nil, the link is emulated for the packets sent by the client.
+// primingTimeout limits the connection gathering the session ticket and token for 0-RTT.
+// if compare0RTT is true, the time to first byte of a 1-RTT connection is compared to the one of a 0-RTT connection.
+// if sessionDir is not empty, session tickets and address tokens are stored in it, so they are used by later runs.
+// migrateTimes are the times after the first byte at which the client migrates to a new UDP socket,
+// bound to the migrateAddrs in turn, or to a new port if there are none.
+// if fctSizes is not nil, fctFlows downloads with sizes of the distribution are measured one after another, each on a new connection.
+func Run(addr net.UDPAddr, timeToFirstByteOnly bool, printRaw bool, createQLog bool, migrateTimes []time.Duration, migrateAddrs []net.IP, proxyAddr *net.UDPAddr, probeTime time.Duration, reportInterval time.Duration, tlsServerCertFile string, tlsProxyCertFile string, initialCongestionWindow uint32, initialReceiveWindow uint64, maxReceiveWindow uint64, use0RTT bool, primingTimeout time.Duration, useProxy0RTT, allowEarlyHandover bool, useXse bool, logPrefix string, qlogPrefix string, http3enabled bool, quiet bool, upload bool, bidirectional bool, parallelStreams uint, parallelConnections uint, blockSize uint64, byteLimit uint64, cc string, rxBandwidth uint64, netemConf *netem.Config, compare0RTT bool, sessionDir string, fctSizes *common.FlowSizeDistribution, fctFlows uint, args cli.Args) {
 	exportFileName = fmt.Sprintf("result/%s_quic.json", logPrefix)
-	c := Client{
-		state:          common.State{},
-		printRaw:       printRaw,
-		reportInterval: reportInterval,
-		StatesHistory:  make([]*States, 0),
-	}
 
-	c.logger = common.DefaultLogger.WithPrefix(logPrefix)
+	logger := common.DefaultLogger.WithPrefix(logPrefix)
 
 	// tracers := make([]logging.ConnectionTracer, 0)
 
@@ -71,18 +123,6 @@ func Run(addr net.UDPAddr, timeToFirstByteOnly bool, printRaw bool, createQLog b
 		tracer = nil
 	}
 
-	// tracers = append(tracers, common.NewEventTracer(common.Handlers{
-	// 	UpdatePath: func(odcid logging.ConnectionID, newRemote net.Addr) {
-	// 		c.logger.Infof("migrated QUIC connection %s to %s at %.3f s", odcid.String(), newRemote, time.Now().Sub(c.state.GetStartTime()).Seconds())
-	// 	},
-	// 	StartedConnection: func(odcid logging.ConnectionID, local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
-	// 		c.logger.Infof("started QUIC connection %s", odcid.String())
-	// 	},
-	// 	ClosedConnection: func(odcid logging.ConnectionID, err error) {
-	// 		c.logger.Infof("closed QUIC connection %s", odcid.String())
-	// 	},
-	// }))
-
 	if initialReceiveWindow > maxReceiveWindow {
 		maxReceiveWindow = initialReceiveWindow
 	}
@@ -113,13 +153,29 @@ func Run(addr net.UDPAddr, timeToFirstByteOnly bool, printRaw bool, createQLog b
 		// c.logger.Infof("stored session ticket and address token of proxy for 0-RTT")
 	}
 
+	use0RTT = use0RTT || compare0RTT
 	var clientSessionCache tls.ClientSessionCache
-	if use0RTT {
-		clientSessionCache = tls.NewLRUClientSessionCache(1)
-	}
-
 	var tokenStore quic.TokenStore
-	if use0RTT {
+	// a session ticket of a previous run makes the priming connection unnecessary
+	storedTicket := false
+	if sessionDir != "" {
+		fileSessionCache, err := common.NewFileSessionCache(sessionDir, logger)
+		if err != nil {
+			panic(err)
+		}
+		tokenStore, err = common.NewFileTokenStore(sessionDir, logger)
+		if err != nil {
+			panic(err)
+		}
+		clientSessionCache = fileSessionCache
+		// quic-go uses the host as server name, which is the key of the session ticket
+		received, ok := fileSessionCache.Received(addr.IP.String())
+		if ok {
+			storedTicket = true
+			logger.Infof("stored session ticket received %s ago", time.Since(received).Round(time.Second))
+		}
+	} else if use0RTT {
+		clientSessionCache = tls.NewLRUClientSessionCache(1)
 		tokenStore = quic.NewLRUTokenStore(1, 1)
 	}
 
@@ -143,111 +199,400 @@ func Run(addr net.UDPAddr, timeToFirstByteOnly bool, printRaw bool, createQLog b
 		// AllowEarlyHandover:                               allowEarlyHandover,
 	}
 
+	if len(migrateTimes) > 0 {
+		conf.KeepAlivePeriod = migrationKeepAlivePeriod
+	}
+
 	// if useXse {
 	// 	conf.ExtraStreamEncryption = quic.EnforceExtraStreamEncryption
 	// } else {
 	// 	conf.ExtraStreamEncryption = quic.DisableExtraStreamEncryption
 	// }
 
-	if use0RTT {
-		err := common.PingToGatherSessionTicketAndToken(addr.String(), tlsConf, &conf)
+	// the comparison gathers the session ticket and token after its 1-RTT connection
+	if use0RTT && !compare0RTT && !storedTicket {
+		ctx, cancel := context.WithTimeout(context.Background(), primingTimeout)
+		err := common.PingToGatherSessionTicketAndToken(ctx, addr.String(), tlsConf, &conf)
+		cancel()
 		if err != nil {
-			panic(fmt.Errorf("failed to prepare 0-RTT: %w", err))
+			logger.Errorf("failed to prepare 0-RTT: %s", err)
+			os.Exit(1)
 		}
-		c.logger.Infof("stored session ticket and token")
+		logger.Infof("stored session ticket and token")
 	}
 
-	c.state.SetStartTime()
+	var transport *quic.Transport
+	var migratingConn *migratingConn
+	if netemConf != nil || len(migrateTimes) > 0 {
+		var conn net.PacketConn
+		var err error
+		if len(migrateTimes) > 0 {
+			migratingConn, err = newMigratingConn()
+			conn = migratingConn
+		} else {
+			conn, err = net.ListenUDP("udp", nil)
+		}
+		if err != nil {
+			panic(err)
+		}
+		if netemConf != nil {
+			conn = netem.NewPacketConn(conn, netemConf)
+			logger.Infof("emulating link: %s", netemConf)
+		}
+		transport = &quic.Transport{Conn: conn}
+		defer transport.Close()
+	}
 
 	if http3enabled {
-		serverHttp3(c.logger, tlsConf, &conf, quiet, args.Slice())
+		if migratingConn != nil {
+			logger.Infof("migration is not supported with http3")
+		}
+		serverHttp3(logger, tlsConf, &conf, quiet, args.Slice(), transport)
 		return
 	}
 
-	var connection quic.Connection
-	ctx := context.Background()
-	if use0RTT {
-		var err error
-		connection, err = quic.DialAddrEarly(ctx, addr.String(), tlsConf, &conf)
-		if err != nil {
-			panic(fmt.Errorf("failed to establish connection: %w", err))
+	parameters := common.TestParameters{
+		Direction:         common.DirectionDownload,
+		Duration:          probeTime,
+		ByteLimit:         byteLimit,
+		BlockSize:         blockSize,
+		CongestionControl: cc,
+		TargetRate:        rxBandwidth,
+		Streams:           uint64(parallelStreams),
+	}
+	var directions []string
+	switch {
+	case bidirectional:
+		parameters.Direction = common.DirectionBidirectional
+		directions = []string{common.DirectionDownload, common.DirectionUpload}
+	case upload:
+		parameters.Direction = common.DirectionUpload
+		directions = []string{common.DirectionUpload}
+	default:
+		directions = []string{common.DirectionDownload}
+	}
+
+	newClient := func(i int) *Client {
+		return &Client{
+			state:           common.State{},
+			printRaw:        printRaw,
+			reportInterval:  reportInterval,
+			logger:          logger,
+			StatesHistory:   make([]*States, 0),
+			connection:      i,
+			parameters:      parameters,
+			parallelStreams: int(parallelStreams),
+			directions:      directions,
+			firstByte:       make(chan struct{}),
+			transport:       transport,
+			migratingConn:   migratingConn,
+		}
+	}
+
+	if compare0RTT {
+		compareTimeToFirstByte(logger, newClient(0), newClient(0), addr.String(), tlsConf, &conf, probeTime, primingTimeout)
+		return
+	}
+
+	if fctSizes != nil {
+		if migratingConn != nil {
+			logger.Infof("migration is not supported with fct")
+		}
+		flows := runFlows(logger, newClient, addr.String(), tlsConf, &conf, use0RTT, fctSizes, fctFlows, printRaw)
+		fctFileName := fmt.Sprintf("result/%s_fct.json", logPrefix)
+		if err := exportStates(flows, fctFileName); err == nil {
+			logger.Infof("export flows success:%s", fctFileName)
+		} else {
+			logger.Infof("export flows error:%s", err.Error())
+		}
+		return
+	}
+
+	clients := make([]*Client, parallelConnections)
+	var wg sync.WaitGroup
+	for i := range clients {
+		c := newClient(i)
+		if parallelConnections > 1 {
+			c.logger = logger.WithPrefix(fmt.Sprintf("connection %d", i))
 		}
+		clients[i] = c
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.run(addr.String(), tlsConf, &conf, use0RTT, timeToFirstByteOnly, probeTime)
+		}()
+	}
+	if migratingConn != nil {
+		go migrateAt(logger, migratingConn, clients[0], migrateTimes, migrateAddrs)
+	}
+	wg.Wait()
+
+	if parallelConnections > 1 && !timeToFirstByteOnly {
+		reportConnections(logger, clients, printRaw)
+	}
+
+	statesHistory := make([]*States, 0)
+	for _, c := range clients {
+		statesHistory = append(statesHistory, c.StatesHistory...)
+	}
+	if err := exportStates(statesHistory, exportFileName); err == nil {
+		logger.Infof("export states success:%s", exportFileName)
 	} else {
-		var err error
-		connection, err = quic.DialAddr(ctx, addr.String(), tlsConf, &conf)
-		if err != nil {
-			panic(fmt.Errorf("failed to establish connection: %w", err))
+		logger.Infof("export states error:%s", err.Error())
+	}
+}
+
+// run a single connection of the measurement.
+func (c *Client) run(addr string, tlsConf *tls.Config, conf *quic.Config, use0RTT bool, timeToFirstByteOnly bool, probeTime time.Duration) {
+	c.state.SetStartTime()
+
+	// count the packets and log the events of this connection, in addition to the qlog tracer
+	events := c.newEventTracer()
+	connectionConf := conf.Clone()
+	connectionConf.Tracer = func(ctx context.Context, p logging.Perspective, connID logging.ConnectionID) *logging.ConnectionTracer {
+		tracers := []*logging.ConnectionTracer{common.NewStateConnectionTracer(&c.state), events.NewConnectionTracer(ctx, p, connID)}
+		if conf.Tracer != nil {
+			tracers = append(tracers, conf.Tracer(ctx, p, connID))
 		}
+		return common.NewMultiplexedConnectionTracer(tracers...)
 	}
 
-	c.state.SetEstablishmentTime()
-	c.reportEstablishmentTime(&c.state)
+	ctx := context.Background()
+	connection, err := c.dial(ctx, addr, tlsConf, connectionConf, use0RTT)
+	if err != nil {
+		panic(fmt.Errorf("failed to establish connection: %w", err))
+	}
+	events.Watch(connection)
+
+	if use0RTT {
+		connection, err = c.helloEarly(ctx, connection.(quic.EarlyConnection))
+	} else {
+		c.state.SetEstablishmentTime()
+		c.reportEstablishmentTime(&c.state)
+		err = c.hello(connection)
+		if err == nil {
+			err = c.openStreams(ctx, connection)
+		}
+	}
+	if err != nil {
+		panic(err)
+	}
 
 	// if connection.ExtraStreamEncrypted() {
 	// 	c.logger.Infof("use XSE-QUIC")
 	// }
 
-	// migrate
-	// if migrateAfter.Nanoseconds() != 0 {
-	// 	go func() {
-	// 		time.Sleep(migrateAfter)
-	// 		addr, err := connection.MigrateUDPSocket()
-	// 		if err != nil {
-	// 			panic(fmt.Errorf("failed to migrate UDP socket: %w", err))
-	// 		}
-	// 		c.logger.Infof("migrated to %s", addr.String())
-	// 	}()
-	// }
+	// the recovery after a migration is found in the samples of the received bytes
+	var sampling sync.WaitGroup
+	stopSampling := make(chan struct{})
+	if c.migratingConn != nil {
+		sampling.Add(1)
+		go func() {
+			defer sampling.Done()
+			c.sampleReceivedBytes(stopSampling)
+		}()
+	}
 
-	// close gracefully on interrupt (CTRL+C)
+	// close gracefully on interrupt (CTRL+C), until the next connection installs its own handler
 	intChan := make(chan os.Signal, 1)
 	signal.Notify(intChan, os.Interrupt)
+	stopInterrupt := make(chan struct{})
+	defer func() {
+		signal.Stop(intChan)
+		close(stopInterrupt)
+	}()
 	go func() {
-		<-intChan
-		_ = connection.CloseWithError(quic.ApplicationErrorCode(quic.NoError), "client_closed")
-		os.Exit(0)
+		select {
+		case <-intChan:
+			_ = connection.CloseWithError(quic.ApplicationErrorCode(quic.NoError), "client_closed")
+			os.Exit(0)
+		case <-stopInterrupt:
+		}
 	}()
 
-	stream, err := connection.OpenStream()
-	if err != nil {
-		panic(fmt.Errorf("failed to open stream: %w", err))
+	<-c.firstByte
+	c.reportFirstByte(&c.state)
+
+	completed := false
+	switch {
+	case timeToFirstByteOnly:
+	case c.parameters.ByteLimit != 0:
+		c.reportUntilCompleted()
+		completed = true
+	default:
+		for {
+			if time.Now().Sub(c.state.GetFirstByteTime()) > probeTime {
+				break
+			}
+			time.Sleep(c.reportInterval)
+			c.report()
+		}
 	}
 
-	// send some date to open stream
-	_, err = stream.Write([]byte(common.QPerfStartSendingRequest))
+	err = connection.CloseWithError(common.RuntimeReachedErrorCode, "runtime_reached")
 	if err != nil {
-		panic(fmt.Errorf("failed to write to stream: %w", err))
+		panic(fmt.Errorf("failed to close connection: %w", err))
+	}
+
+	c.endTime = time.Now()
+	if completed {
+		c.endTime = c.completionTime()
+	}
+	close(stopSampling)
+	sampling.Wait()
+	c.reportTotal()
+	if completed {
+		c.reportCompletion()
+	}
+	if c.migratingConn != nil {
+		c.reportMigrations(c.migratingConn.history())
 	}
-	err = stream.Close()
+}
+
+// dial uses the emulated link if there is one.
+func (c *Client) dial(ctx context.Context, addr string, tlsConf *tls.Config, conf *quic.Config, use0RTT bool) (quic.Connection, error) {
+	if c.transport == nil {
+		if use0RTT {
+			return quic.DialAddrEarly(ctx, addr, tlsConf, conf)
+		}
+		return quic.DialAddr(ctx, addr, tlsConf, conf)
+	}
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
 	if err != nil {
-		panic(fmt.Errorf("failed to close stream: %w", err))
+		return nil, err
 	}
+	if use0RTT {
+		return c.transport.DialEarly(ctx, udpAddr, tlsConf, conf)
+	}
+	return c.transport.Dial(ctx, udpAddr, tlsConf, conf)
+}
 
-	err = c.receiveFirstByte(stream)
+// useCongestionControl replaces the congestion control of the connection with the requested one.
+// brutal and rl are only used by the server.
+func (c *Client) useCongestionControl(connection quic.Connection) {
+	switch c.parameters.CongestionControl {
+	case common.CC_CUBIC:
+		congestion.UseCubic(connection, false)
+	case common.CC_RENO:
+		congestion.UseCubic(connection, true)
+	case common.CC_BBR:
+		c.bbrState = congestion.UseBBR(connection)
+	default:
+		return
+	}
+	c.logger.Infof("using %s cc for sending", c.parameters.CongestionControl)
+}
+
+// hello sends the test parameters on the control stream and waits for the server to accept them.
+func (c *Client) hello(connection quic.Connection) error {
+	controlStream, err := c.sendHello(connection)
 	if err != nil {
-		panic(fmt.Errorf("failed to receive first byte: %w", err))
+		return err
 	}
+	return c.receiveHelloAck(connection, controlStream)
+}
 
-	c.reportFirstByte(&c.state)
+// sendHello sends the test parameters on a new control stream.
+func (c *Client) sendHello(connection quic.Connection) (quic.Stream, error) {
+	controlStream, err := connection.OpenStream()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open control stream: %w", err)
+	}
+	err = common.WriteControlMessage(controlStream, common.ControlMessageHello, &c.parameters)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send test parameters: %w", err)
+	}
+	return controlStream, nil
+}
 
-	if !timeToFirstByteOnly {
-		go c.receive(stream)
+// receiveHelloAck waits for the server to accept the test parameters.
+func (c *Client) receiveHelloAck(connection quic.Connection, controlStream quic.Stream) error {
+	capabilities := common.ServerCapabilities{}
+	err := common.ReadControlMessage(controlStream, common.ControlMessageHelloAck, &capabilities)
+	if err != nil {
+		return fmt.Errorf("failed to receive server capabilities: %w", err)
+	}
+	if !capabilities.Accepted {
+		_ = connection.CloseWithError(common.RuntimeReachedErrorCode, "rejected")
+		return fmt.Errorf("server rejected test: %s", capabilities.Error)
+	}
+	c.logger.Debugf("server capabilities: %+v", capabilities)
+	c.logger.Infof("server congestion control: %s", capabilities.CongestionControl)
+	return nil
+}
 
-		for {
-			if time.Now().Sub(c.state.GetFirstByteTime()) > probeTime {
-				break
+// openStreams opens the data streams of all directions, the server handles them after the hello.
+func (c *Client) openStreams(ctx context.Context, connection quic.Connection) error {
+	// the requested cc is also used by the client if it sends data
+	if c.parameters.Direction != common.DirectionDownload {
+		c.useCongestionControl(connection)
+	}
+	for _, direction := range c.directions {
+		for i := 0; i < c.parallelStreams; i++ {
+			stream, err := connection.OpenStreamSync(ctx)
+			if err != nil {
+				return fmt.Errorf("failed to open stream: %w", err)
 			}
-			time.Sleep(reportInterval)
-			c.report(&c.state)
+			clientStream := newClientStream(c, stream, direction)
+			c.streams = append(c.streams, clientStream)
+			go clientStream.run()
 		}
 	}
+	return nil
+}
 
-	err = connection.CloseWithError(common.RuntimeReachedErrorCode, "runtime_reached")
+// helloEarly sends the hello and the requests of the data streams in 0-RTT, before the handshake is complete.
+// If the server rejects 0-RTT, they are sent again in 1-RTT on the returned connection.
+func (c *Client) helloEarly(ctx context.Context, early quic.EarlyConnection) (quic.Connection, error) {
+	helloErr := make(chan error, 1)
+	go func() {
+		controlStream, err := c.sendHello(early)
+		if err == nil {
+			err = c.openStreams(ctx, early)
+		}
+		if err == nil {
+			err = c.receiveHelloAck(early, controlStream)
+		}
+		helloErr <- err
+	}()
+	err := common.AwaitHandshake(early)
 	if err != nil {
-		panic(fmt.Errorf("failed to close connection: %w", err))
+		return nil, fmt.Errorf("handshake failed: %w", err)
 	}
+	c.state.SetEstablishmentTime()
+	c.reportEstablishmentTime(&c.state)
 
-	c.reportTotal(&c.state)
+	var connection quic.Connection = early
+	err = <-helloErr
+	rejected := errors.Is(err, quic.Err0RTTRejected)
+	if rejected {
+		// the streams of the early connection end with the rejection
+		connection = early.NextConnection()
+		c.streams = nil
+		err = c.hello(connection)
+		if err == nil {
+			err = c.openStreams(ctx, connection)
+		}
+	}
+	c.used0RTT = connection.ConnectionState().Used0RTT
+	switch {
+	case c.used0RTT:
+		c.logger.Infof("0-RTT accepted")
+	case rejected:
+		c.logger.Infof("0-RTT rejected by the server, hello and streams sent again in 1-RTT")
+	case connection.ConnectionState().TLS.DidResume:
+		c.logger.Infof("0-RTT not used, the session ticket does not allow early data")
+	default:
+		c.logger.Infof("0-RTT not used, no session ticket")
+	}
+	return connection, err
+}
+
+// newEventTracer logs the events of the connection relative to the start of the measurement.
+func (c *Client) newEventTracer() *common.EventTracer {
+	return common.NewEventTracer(common.LogEvents(c.logger, func(logging.ConnectionID) time.Duration {
+		return time.Since(c.state.StartTime())
+	}))
 }
 
 func (c *Client) reportEstablishmentTime(state *common.State) {
@@ -271,91 +616,394 @@ func (c *Client) reportFirstByte(state *common.State) {
 	}
 }
 
-func (c *Client) report(state *common.State) {
-	receivedBytes, receivedPackets, delta := state.GetAndResetReport()
+// addReceivedBytes is called by the streams to track the first byte of the connection.
+func (c *Client) addReceivedBytes(receivedBytes uint64) {
+	c.state.AddReceivedBytes(receivedBytes)
+	if receivedBytes != 0 {
+		c.firstByteOnce.Do(func() {
+			close(c.firstByte)
+		})
+	}
+}
+
+// directionLabel returns the prefix of a report line,
+// the direction is only printed if both directions are measured.
+func (c *Client) directionLabel(direction string) string {
+	if len(c.directions) > 1 {
+		return direction + " "
+	}
+	return ""
+}
+
+// reportUntilCompleted reports every interval until the server closed all streams after the byte limit,
+// the last report covers the remaining part of an interval.
+func (c *Client) reportUntilCompleted() {
+	completed := make(chan struct{})
+	go func() {
+		for _, stream := range c.streams {
+			<-stream.completed
+		}
+		close(completed)
+	}()
+	ticker := time.NewTicker(c.reportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-completed:
+			c.report()
+			return
+		case <-ticker.C:
+			c.report()
+		}
+	}
+}
+
+// completionTime returns the time the last stream was completed.
+func (c *Client) completionTime() time.Time {
+	var completionTime time.Time
+	for _, stream := range c.streams {
+		completionTime = common.MaxTime([]time.Time{completionTime, stream.completionTime})
+	}
+	return completionTime
+}
+
+func (c *Client) report() {
+	packets := c.state.GetAndResetPacketReport()
+	for _, direction := range c.directions {
+		c.reportDirection(direction, packets)
+	}
+	c.logPackets(packets)
+	if c.bbrState != nil {
+		c.logger.Infof("bbr: %s", c.bbrState.State())
+	}
+}
+
+// reportDirection reports all streams of the direction and their sum.
+// The packets of the connection are reported as received packets of the download direction.
+func (c *Client) reportDirection(direction string, packets common.PacketCounters) {
+	var receivedBytes, receivedPackets uint64
+	var delta time.Duration
+	label := c.directionLabel(direction)
+	for _, stream := range c.streams {
+		if stream.direction != direction {
+			continue
+		}
+		streamBytes, streamPackets, streamDelta := stream.state.GetAndResetReport()
+		if c.parallelStreams > 1 {
+			c.logReport(stream.logger, label, streamBytes, streamPackets, streamDelta)
+		}
+		receivedBytes += streamBytes
+		receivedPackets += streamPackets
+		if streamDelta > delta {
+			delta = streamDelta
+		}
+	}
+	if direction == common.DirectionDownload {
+		receivedPackets = packets.ReceivedPackets
+	}
+	c.logReport(c.sumLogger(), label, receivedBytes, receivedPackets, delta)
+	c.StatesHistory = append(c.StatesHistory, &States{
+		RateBits:   float64(receivedBytes) * 8 / delta.Seconds(),
+		Bytes:      receivedBytes,
+		Second:     int(time.Now().Sub(c.state.GetFirstByteTime()).Seconds()),
+		Packets:    receivedPackets,
+		Direction:  direction,
+		Connection: c.connection,
+
+		SentPackets:       packets.SentPackets,
+		LostPackets:       packets.LostPackets,
+		WireBytesSent:     packets.SentBytes,
+		WireBytesReceived: packets.ReceivedBytes,
+	})
+}
+
+// logPackets reports the QUIC packets of the connection, including retransmissions and acknowledgements.
+func (c *Client) logPackets(packets common.PacketCounters) {
+	if c.printRaw {
+		c.logger.Infof("packets sent: %d (%d B), received: %d (%d B), lost: %d",
+			packets.SentPackets, packets.SentBytes, packets.ReceivedPackets, packets.ReceivedBytes, packets.LostPackets)
+	} else {
+		c.logger.Infof("packets sent: %d (%s), received: %d (%s), lost: %d",
+			packets.SentPackets, humanize.SI(float64(packets.SentBytes), "B"),
+			packets.ReceivedPackets, humanize.SI(float64(packets.ReceivedBytes), "B"),
+			packets.LostPackets)
+	}
+}
+
+// sumLogger returns the logger for the aggregated reports of all streams.
+func (c *Client) sumLogger() common.Logger {
+	if c.parallelStreams > 1 {
+		return c.logger.WithPrefix("sum")
+	}
+	return c.logger
+}
 
+func (c *Client) logReport(logger common.Logger, label string, receivedBytes uint64, receivedPackets uint64, delta time.Duration) {
 	if c.printRaw {
-		c.logger.Infof("second %f: %f bit/s, bytes received: %d B, packets received: %d",
-			time.Now().Sub(state.GetFirstByteTime()).Seconds(),
+		logger.Infof("second %f: %s%f bit/s, bytes received: %d B, packets received: %d",
+			time.Now().Sub(c.state.GetFirstByteTime()).Seconds(),
+			label,
 			float64(receivedBytes)*8/delta.Seconds(),
 			receivedBytes,
 			receivedPackets)
 	} else if c.reportInterval == time.Second {
-		c.logger.Infof("second %.0f: %s, bytes received: %s, packets received: %d",
-			time.Now().Sub(state.GetFirstByteTime()).Seconds(),
+		logger.Infof("second %.0f: %s%s, bytes received: %s, packets received: %d",
+			time.Now().Sub(c.state.GetFirstByteTime()).Seconds(),
+			label,
 			humanize.SIWithDigits(float64(receivedBytes)*8/delta.Seconds(), 2, "bit/s"),
 			humanize.SI(float64(receivedBytes), "B"),
 			receivedPackets)
 	} else {
-		c.logger.Infof("second %.1f: %s, bytes received: %s, packets received: %d",
-			time.Now().Sub(state.GetFirstByteTime()).Seconds(),
+		logger.Infof("second %.1f: %s%s, bytes received: %s, packets received: %d",
+			time.Now().Sub(c.state.GetFirstByteTime()).Seconds(),
+			label,
 			humanize.SIWithDigits(float64(receivedBytes)*8/delta.Seconds(), 2, "bit/s"),
 			humanize.SI(float64(receivedBytes), "B"),
 			receivedPackets)
 	}
-	c.StatesHistory = append(c.StatesHistory, &States{
-		RateBits: float64(receivedBytes) * 8 / delta.Seconds(),
-		Bytes:    receivedBytes,
-		Second:   int(time.Now().Sub(state.GetFirstByteTime()).Seconds()),
-		Packets:  receivedPackets,
-	})
 }
 
-func (c *Client) reportTotal(state *common.State) {
-	receivedBytes, receivedPackets := state.Total()
+func (c *Client) reportTotal() {
+	for _, direction := range c.directions {
+		var receivedBytes, receivedPackets uint64
+		label := c.directionLabel(direction)
+		for _, stream := range c.streams {
+			if stream.direction != direction {
+				continue
+			}
+			streamBytes, streamPackets := stream.state.Total()
+			if c.parallelStreams > 1 {
+				c.logTotal(stream.logger, label, streamBytes, streamPackets)
+			}
+			receivedBytes += streamBytes
+			receivedPackets += streamPackets
+		}
+		if direction == common.DirectionDownload {
+			receivedPackets = c.state.Packets().ReceivedPackets
+		}
+		c.logTotal(c.sumLogger(), label, receivedBytes, receivedPackets)
+	}
+	c.logPackets(c.state.Packets())
+}
+
+// reportCompletion reports the flow completion time, measured from the start of the connection to the last byte,
+// and the goodput over the flow completion time.
+func (c *Client) reportCompletion() {
+	var receivedBytes uint64
+	for _, stream := range c.streams {
+		streamBytes, _ := stream.state.Total()
+		if c.parallelStreams > 1 {
+			c.logCompletion(stream.logger, streamBytes, stream.completionTime)
+		}
+		receivedBytes += streamBytes
+	}
+	c.logCompletion(c.sumLogger(), receivedBytes, c.endTime)
+}
+
+func (c *Client) logCompletion(logger common.Logger, receivedBytes uint64, completionTime time.Time) {
+	fct := completionTime.Sub(c.state.StartTime())
+	afterFirstByte := completionTime.Sub(c.state.GetFirstByteTime())
 	if c.printRaw {
-		c.logger.Infof("total: bytes received: %d B, packets received: %d",
-			receivedBytes,
-			receivedPackets)
+		logger.Infof("flow completion time: %f s (%f s after first byte), goodput: %f bit/s",
+			fct.Seconds(),
+			afterFirstByte.Seconds(),
+			float64(receivedBytes)*8/fct.Seconds())
 	} else {
-		c.logger.Infof("total: bytes received: %s, packets received: %d",
-			humanize.SI(float64(receivedBytes), "B"),
-			receivedPackets)
+		logger.Infof("flow completion time: %s (%s after first byte), goodput: %s",
+			humanize.SIWithDigits(fct.Seconds(), 2, "s"),
+			humanize.SIWithDigits(afterFirstByte.Seconds(), 2, "s"),
+			humanize.SIWithDigits(float64(receivedBytes)*8/fct.Seconds(), 2, "bit/s"))
+	}
+}
+
+// totalRate returns the average rate of all streams of the direction in bit/s.
+func (c *Client) totalRate(direction string) (receivedBytes uint64, rateBits float64) {
+	for _, stream := range c.streams {
+		if stream.direction != direction {
+			continue
+		}
+		streamBytes, _ := stream.state.Total()
+		receivedBytes += streamBytes
+	}
+	duration := c.endTime.Sub(c.state.GetFirstByteTime())
+	return receivedBytes, float64(receivedBytes) * 8 / duration.Seconds()
+}
+
+// compareTimeToFirstByte measures the time to first byte of a 1-RTT connection without resumption,
+// and of a 0-RTT connection with a session ticket and token gathered in between.
+func compareTimeToFirstByte(logger common.Logger, oneRTT *Client, zeroRTT *Client, addr string, tlsConf *tls.Config, conf *quic.Config, probeTime time.Duration, primingTimeout time.Duration) {
+	// the stored session ticket and token must not be used by the 1-RTT connection
+	oneRTTTlsConf := tlsConf.Clone()
+	oneRTTTlsConf.ClientSessionCache = nil
+	oneRTTConf := conf.Clone()
+	oneRTTConf.TokenStore = nil
+	oneRTT.logger = logger.WithPrefix("1-RTT")
+	oneRTT.run(addr, oneRTTTlsConf, oneRTTConf, false, true, probeTime)
+
+	ctx, cancel := context.WithTimeout(context.Background(), primingTimeout)
+	err := common.PingToGatherSessionTicketAndToken(ctx, addr, tlsConf, conf)
+	cancel()
+	if err != nil {
+		logger.Errorf("failed to prepare 0-RTT: %s", err)
+		os.Exit(1)
 	}
-	if err := c.exportStates(exportFileName); err == nil {
-		c.logger.Infof("export states success:%s", exportFileName)
+	zeroRTT.logger = logger.WithPrefix("0-RTT")
+	zeroRTT.run(addr, tlsConf, conf, true, true, probeTime)
+
+	oneRTTTime := oneRTT.state.GetFirstByteTime().Sub(oneRTT.state.StartTime())
+	zeroRTTTime := zeroRTT.state.GetFirstByteTime().Sub(zeroRTT.state.StartTime())
+	if oneRTT.printRaw {
+		logger.Infof("time to first byte: 1-RTT %f s, 0-RTT %f s, difference %f s, 0-RTT used: %t",
+			oneRTTTime.Seconds(), zeroRTTTime.Seconds(), (oneRTTTime - zeroRTTTime).Seconds(), zeroRTT.used0RTT)
 	} else {
-		c.logger.Infof("export states error:%s", err.Error())
+		logger.Infof("time to first byte: 1-RTT %s, 0-RTT %s, difference %s, 0-RTT used: %t",
+			humanize.SIWithDigits(oneRTTTime.Seconds(), 2, "s"),
+			humanize.SIWithDigits(zeroRTTTime.Seconds(), 2, "s"),
+			humanize.SIWithDigits((oneRTTTime-zeroRTTTime).Seconds(), 2, "s"),
+			zeroRTT.used0RTT)
 	}
+}
+
+// runFlows downloads flows one after another, each on a new connection with a single stream and a size drawn from sizes,
+// and reports the flow completion time of every flow and their distribution per size.
+// The output of the connections themselves is limited to errors.
+func runFlows(logger common.Logger, newClient func(int) *Client, addr string, tlsConf *tls.Config, conf *quic.Config, use0RTT bool, sizes *common.FlowSizeDistribution, flows uint, printRaw bool) []*FlowResult {
+	logger.Infof("measuring %d flows with sizes %s", flows, sizes)
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	results := make([]*FlowResult, 0, flows)
+	for i := 0; i < int(flows); i++ {
+		c := newClient(i)
+		c.parameters.ByteLimit = sizes.Sample(random)
+		c.parameters.Streams = 1
+		c.parallelStreams = 1
+		c.logger = logger.WithPrefix(fmt.Sprintf("flow %d", i))
+		c.logger.SetLogLevel(common.LogLevelError)
+		c.run(addr, tlsConf, conf, use0RTT, false, 0)
 
+		result := &FlowResult{
+			Flow:            i,
+			Size:            c.parameters.ByteLimit,
+			CompletionTime:  c.endTime.Sub(c.state.StartTime()).Seconds(),
+			TimeToFirstByte: c.state.GetFirstByteTime().Sub(c.state.StartTime()).Seconds(),
+			Used0RTT:        c.used0RTT,
+		}
+		results = append(results, result)
+		logger.WithPrefix(fmt.Sprintf("flow %d", i)).Infof("size %s, completion time %s, time to first byte %s, goodput %s, 0-RTT used: %t",
+			humanize.IBytes(result.Size),
+			formatSeconds(result.CompletionTime, printRaw),
+			formatSeconds(result.TimeToFirstByte, printRaw),
+			formatRate(float64(result.Size)*8/result.CompletionTime, printRaw),
+			result.Used0RTT)
+	}
+
+	reportFlows(logger, results, sizes.Sizes, printRaw)
+	return results
 }
 
-func (c *Client) receiveFirstByte(stream quic.ReceiveStream) error {
-	buf := make([]byte, 1)
-	for {
-		received, err := stream.Read(buf)
-		if err != nil {
-			return err
+// reportFlows prints the mean and percentiles of the flow completion times per size, and of all flows if there are multiple sizes.
+func reportFlows(logger common.Logger, results []*FlowResult, sizes []uint64, printRaw bool) {
+	var all []float64
+	for i, size := range sizes {
+		if slices.Index(sizes, size) != i {
+			continue
 		}
-		if received != 0 {
-			c.state.AddReceivedBytes(uint64(received))
-			return nil
+		var completionTimes []float64
+		for _, result := range results {
+			if result.Size == size {
+				completionTimes = append(completionTimes, result.CompletionTime)
+			}
 		}
+		all = append(all, completionTimes...)
+		logFlowCompletionTimes(logger.WithPrefix(humanize.IBytes(size)), completionTimes, printRaw)
+	}
+	if len(sizes) > 1 {
+		logFlowCompletionTimes(logger.WithPrefix("all"), all, printRaw)
 	}
 }
 
-func (c *Client) receive(reader io.Reader) {
-	buf := make([]byte, 65536)
-	for {
-		received, err := reader.Read(buf)
-		c.state.AddReceivedBytes(uint64(received))
-		if err != nil {
-			switch err := err.(type) {
-			case *quic.ApplicationError:
-				if err.ErrorCode == common.RuntimeReachedErrorCode {
-					return
-				}
-			default:
-				panic(err)
-			}
+func logFlowCompletionTimes(logger common.Logger, completionTimes []float64, printRaw bool) {
+	if len(completionTimes) == 0 {
+		logger.Infof("no flows")
+		return
+	}
+	var sum float64
+	for _, completionTime := range completionTimes {
+		sum += completionTime
+	}
+	logger.Infof("flows: %d, completion time mean: %s, median: %s, p95: %s, p99: %s, max: %s",
+		len(completionTimes),
+		formatSeconds(sum/float64(len(completionTimes)), printRaw),
+		formatSeconds(common.Percentile(completionTimes, 50), printRaw),
+		formatSeconds(common.Percentile(completionTimes, 95), printRaw),
+		formatSeconds(common.Percentile(completionTimes, 99), printRaw),
+		formatSeconds(common.Percentile(completionTimes, 100), printRaw))
+}
+
+func formatSeconds(seconds float64, printRaw bool) string {
+	if printRaw {
+		return fmt.Sprintf("%f s", seconds)
+	}
+	return humanize.SIWithDigits(seconds, 2, "s")
+}
+
+func formatRate(rateBits float64, printRaw bool) string {
+	if printRaw {
+		return fmt.Sprintf("%f bit/s", rateBits)
+	}
+	return humanize.SIWithDigits(rateBits, 2, "bit/s")
+}
+
+// reportConnections prints the throughput of each connection, their sum and Jain's fairness index.
+func reportConnections(logger common.Logger, clients []*Client, printRaw bool) {
+	for _, direction := range clients[0].directions {
+		label := clients[0].directionLabel(direction)
+		rates := make([]float64, len(clients))
+		var sumBytes uint64
+		var sumRate float64
+		for i, c := range clients {
+			receivedBytes, rateBits := c.totalRate(direction)
+			rates[i] = rateBits
+			sumBytes += receivedBytes
+			sumRate += rateBits
+			logConnectionRate(logger.WithPrefix(fmt.Sprintf("connection %d", c.connection)), label, receivedBytes, rateBits, printRaw)
 		}
+		logConnectionRate(logger.WithPrefix("sum"), label, sumBytes, sumRate, printRaw)
+		logger.Infof("%sfairness: Jain's index: %.4f", label, common.JainsFairnessIndex(rates))
+	}
+}
+
+func logConnectionRate(logger common.Logger, label string, receivedBytes uint64, rateBits float64, printRaw bool) {
+	if printRaw {
+		logger.Infof("%saverage: %f bit/s, bytes received: %d B",
+			label,
+			rateBits,
+			receivedBytes)
+	} else {
+		logger.Infof("%saverage: %s, bytes received: %s",
+			label,
+			humanize.SIWithDigits(rateBits, 2, "bit/s"),
+			humanize.SI(float64(receivedBytes), "B"))
+	}
+}
+
+func (c *Client) logTotal(logger common.Logger, label string, receivedBytes uint64, receivedPackets uint64) {
+	if c.printRaw {
+		logger.Infof("total: %sbytes received: %d B, packets received: %d",
+			label,
+			receivedBytes,
+			receivedPackets)
+	} else {
+		logger.Infof("total: %sbytes received: %s, packets received: %d",
+			label,
+			humanize.SI(float64(receivedBytes), "B"),
+			receivedPackets)
 	}
 }
 
 // '[{"col 1":"a","col 2":"b"},{"col 1":"c","col 2":"d"}]' 形式导出
 // pd.read_json(_, orient='records') 导入
-func (c *Client) exportStates(fileName string) error {
-	b, err := json.MarshalIndent(c.StatesHistory, "", "\t")
+func exportStates(statesHistory any, fileName string) error {
+	b, err := json.MarshalIndent(statesHistory, "", "\t")
 	if err != nil {
 		return err
 	}
@@ -371,11 +1019,21 @@ func (c *Client) exportStates(fileName string) error {
 	return nil
 }
 
-func serverHttp3(logger common.Logger, tlsconf *tls.Config, quicConf *quic.Config, quiet bool, urls []string) {
+func serverHttp3(logger common.Logger, tlsconf *tls.Config, quicConf *quic.Config, quiet bool, urls []string, transport *quic.Transport) {
 	roundTripper := &http3.RoundTripper{
 		TLSClientConfig: tlsconf,
 		QuicConfig:      quicConf,
 	}
+	// use the emulated link
+	if transport != nil {
+		roundTripper.Dial = func(ctx context.Context, addr string, tlsCfg *tls.Config, cfg *quic.Config) (quic.EarlyConnection, error) {
+			udpAddr, err := net.ResolveUDPAddr("udp", addr)
+			if err != nil {
+				return nil, err
+			}
+			return transport.DialEarly(ctx, udpAddr, tlsCfg, cfg)
+		}
+	}
 	defer roundTripper.Close()
 	hclient := &http.Client{
 		Transport: roundTripper,
@@ -0,0 +1,126 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/apernet/quic-go"
+	"io"
+	"qperf-go/common"
+	"time"
+)
+
+// clientStream is a single data stream of the measurement.
+// Depending on the direction the server either sends data on this stream (download),
+// or the client sends data and the server reports the received bytes back (upload).
+type clientStream struct {
+	client    *Client
+	stream    quic.Stream
+	direction string
+	logger    common.Logger
+	// download: bytes received by the client, upload: bytes received by the server
+	state common.State
+	// closed when the server closed the stream after the byte limit
+	completed      chan struct{}
+	completionTime time.Time
+}
+
+func newClientStream(client *Client, stream quic.Stream, direction string) *clientStream {
+	s := &clientStream{
+		client:    client,
+		stream:    stream,
+		direction: direction,
+		logger:    client.logger.WithPrefix(fmt.Sprintf("stream %d", stream.StreamID())),
+		completed: make(chan struct{}),
+	}
+	s.state.SetStartTime()
+	return s
+}
+
+// run sends the request and handles the stream until the connection is closed.
+func (s *clientStream) run() {
+	err := common.WriteControlMessage(s.stream, common.ControlMessageStreamRequest, &common.StreamRequest{
+		Direction: s.direction,
+	})
+	if err != nil {
+		if streamEnded(err) {
+			return
+		}
+		panic(fmt.Errorf("failed to write to stream: %w", err))
+	}
+	switch s.direction {
+	case common.DirectionUpload:
+		// the data directly follows the request
+		go s.send()
+		s.receiveReports()
+	default:
+		err = s.stream.Close()
+		if err != nil && !streamEnded(err) {
+			panic(fmt.Errorf("failed to close stream: %w", err))
+		}
+		s.receive()
+	}
+}
+
+func (s *clientStream) addReceivedBytes(receivedBytes uint64) {
+	s.state.AddReceivedBytes(receivedBytes)
+	s.client.addReceivedBytes(receivedBytes)
+}
+
+// receive reads data until the connection is closed or the server closes the stream.
+func (s *clientStream) receive() {
+	buf := make([]byte, s.client.parameters.BlockSize)
+	for {
+		received, err := s.stream.Read(buf)
+		s.addReceivedBytes(uint64(received))
+		if err == io.EOF {
+			s.completionTime = time.Now()
+			close(s.completed)
+			return
+		}
+		if err != nil {
+			if streamEnded(err) {
+				return
+			}
+			panic(err)
+		}
+	}
+}
+
+// send writes data until the connection is closed.
+func (s *clientStream) send() {
+	buf := make([]byte, s.client.parameters.BlockSize)
+	for {
+		_, err := s.stream.Write(buf)
+		if err != nil {
+			if streamEnded(err) {
+				return
+			}
+			panic(err)
+		}
+	}
+}
+
+// receiveReports adds the bytes received by the server to the stream state.
+func (s *clientStream) receiveReports() {
+	for {
+		report := common.ReceiveReport{}
+		err := common.ReadControlMessage(s.stream, common.ControlMessageReceiveReport, &report)
+		if err != nil {
+			if streamEnded(err) {
+				return
+			}
+			panic(err)
+		}
+		s.addReceivedBytes(report.Bytes)
+		s.state.AddReceivedPackets(report.Packets)
+	}
+}
+
+// streamEnded reports whether the stream ended because the client closed the connection,
+// or because the server rejected 0-RTT and the stream is opened again in 1-RTT.
+func streamEnded(err error) bool {
+	if err, ok := err.(*quic.ApplicationError); ok && err.ErrorCode == common.RuntimeReachedErrorCode {
+		return true
+	}
+	return errors.Is(err, quic.Err0RTTRejected)
+}
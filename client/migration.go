@@ -0,0 +1,254 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"net"
+	"qperf-go/common"
+	"sync"
+	"time"
+)
+
+const (
+	// migrationSampleInterval is the interval in which the received bytes are sampled to find the recovery after a migration.
+	migrationSampleInterval = 10 * time.Millisecond
+	// migrationRateWindow is the window of the rates compared before and after a migration.
+	migrationRateWindow = 100 * time.Millisecond
+	// migrationRecoveredShare of the rate before a migration is reached again when the connection has recovered.
+	migrationRecoveredShare = 0.9
+	// migrationKeepAlivePeriod makes the client send a PING when it received nothing for 1.5 PTO, the minimum of quic-go.
+	// A client only sending ACKs has nothing to send after a migration otherwise,
+	// so the server never learns the new address and keeps sending to the closed socket.
+	migrationKeepAlivePeriod = time.Millisecond
+)
+
+// migratingConn is a UDP socket which is replaced by a new one on every migration,
+// while the quic.Transport using it keeps running.
+// The connections keep their connection IDs, the server follows the new address of the client.
+type migratingConn struct {
+	mutex sync.Mutex
+	conn  *net.UDPConn
+	// buffer sizes set by quic-go, applied to the new sockets
+	readBuffer  int
+	writeBuffer int
+	migrations  []*migration
+	closed      bool
+}
+
+// migration is a change of the local socket.
+type migration struct {
+	time  time.Time
+	local net.Addr
+	// first packet received on the new socket
+	firstPacket time.Time
+}
+
+// receivedSample is the number of bytes received by a connection at a time.
+type receivedSample struct {
+	time  time.Time
+	bytes uint64
+}
+
+func newMigratingConn() (*migratingConn, error) {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return nil, err
+	}
+	return &migratingConn{conn: conn}, nil
+}
+
+func (m *migratingConn) current() *net.UDPConn {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	return m.conn
+}
+
+// migrate binds a new socket to localIP, or to the address of the current socket if localIP is nil,
+// and closes the current one. Packets arriving at the old socket afterwards are lost.
+func (m *migratingConn) migrate(localIP net.IP) (*migration, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	if m.closed {
+		return nil, net.ErrClosed
+	}
+	if localIP == nil {
+		localIP = m.conn.LocalAddr().(*net.UDPAddr).IP
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: localIP})
+	if err != nil {
+		return nil, err
+	}
+	if m.readBuffer != 0 {
+		_ = conn.SetReadBuffer(m.readBuffer)
+	}
+	if m.writeBuffer != 0 {
+		_ = conn.SetWriteBuffer(m.writeBuffer)
+	}
+	old := m.conn
+	m.conn = conn
+	_ = old.Close()
+	event := &migration{
+		time:  time.Now(),
+		local: conn.LocalAddr(),
+	}
+	m.migrations = append(m.migrations, event)
+	return event, nil
+}
+
+// history returns copies of the migrations so far.
+func (m *migratingConn) history() []migration {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	migrations := make([]migration, len(m.migrations))
+	for i, event := range m.migrations {
+		migrations[i] = *event
+	}
+	return migrations
+}
+
+func (m *migratingConn) ReadFrom(p []byte) (int, net.Addr, error) {
+	for {
+		conn := m.current()
+		n, addr, err := conn.ReadFrom(p)
+		m.mutex.Lock()
+		if err != nil && errors.Is(err, net.ErrClosed) && conn != m.conn && !m.closed {
+			// closed by migrate, continue with the new socket
+			m.mutex.Unlock()
+			continue
+		}
+		if err == nil && conn == m.conn && len(m.migrations) > 0 {
+			if last := m.migrations[len(m.migrations)-1]; last.firstPacket.IsZero() {
+				last.firstPacket = time.Now()
+			}
+		}
+		m.mutex.Unlock()
+		return n, addr, err
+	}
+}
+
+func (m *migratingConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	return m.current().WriteTo(p, addr)
+}
+
+func (m *migratingConn) Close() error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.closed = true
+	return m.conn.Close()
+}
+
+func (m *migratingConn) LocalAddr() net.Addr {
+	return m.current().LocalAddr()
+}
+
+func (m *migratingConn) SetDeadline(t time.Time) error {
+	return m.current().SetDeadline(t)
+}
+
+func (m *migratingConn) SetReadDeadline(t time.Time) error {
+	return m.current().SetReadDeadline(t)
+}
+
+func (m *migratingConn) SetWriteDeadline(t time.Time) error {
+	return m.current().SetWriteDeadline(t)
+}
+
+// SetReadBuffer is used by quic-go to increase the receive buffer.
+func (m *migratingConn) SetReadBuffer(bytes int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.readBuffer = bytes
+	return m.conn.SetReadBuffer(bytes)
+}
+
+// SetWriteBuffer is used by quic-go to increase the send buffer.
+func (m *migratingConn) SetWriteBuffer(bytes int) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	m.writeBuffer = bytes
+	return m.conn.SetWriteBuffer(bytes)
+}
+
+// migrateAt migrates conn at the times after the first byte of the first connection.
+// The local addresses are used in turn, a new port on the current address is used if there are none.
+func migrateAt(logger common.Logger, conn *migratingConn, first *Client, times []time.Duration, addrs []net.IP) {
+	<-first.firstByte
+	start := first.state.GetFirstByteTime()
+	for i, t := range times {
+		time.Sleep(time.Until(start.Add(t)))
+		var localIP net.IP
+		if len(addrs) > 0 {
+			localIP = addrs[i%len(addrs)]
+		}
+		event, err := conn.migrate(localIP)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			panic(fmt.Errorf("failed to migrate UDP socket: %w", err))
+		}
+		logger.Infof("second %.3f: migrated to %s", event.time.Sub(start).Seconds(), event.local)
+	}
+}
+
+// sampleReceivedBytes samples the received QUIC bytes of the connection until done is closed.
+func (c *Client) sampleReceivedBytes(done <-chan struct{}) {
+	ticker := time.NewTicker(migrationSampleInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case now := <-ticker.C:
+			c.receivedSamples = append(c.receivedSamples, receivedSample{time: now, bytes: c.state.Packets().ReceivedBytes})
+		}
+	}
+}
+
+// receivedAt returns the bytes of the last sample not after t.
+func (c *Client) receivedAt(t time.Time) uint64 {
+	var bytes uint64
+	for _, sample := range c.receivedSamples {
+		if sample.time.After(t) {
+			break
+		}
+		bytes = sample.bytes
+	}
+	return bytes
+}
+
+// reportMigrations logs the time until the first packet arrived at the new socket,
+// the receive rate before each migration and the time until it was reached again.
+func (c *Client) reportMigrations(migrations []migration) {
+	start := c.state.GetFirstByteTime()
+	for i, m := range migrations {
+		if m.time.Before(start) || m.time.After(c.endTime) {
+			continue
+		}
+		firstPacket := "no packet received"
+		if !m.firstPacket.IsZero() {
+			firstPacket = fmt.Sprintf("first packet after %s", humanize.SIWithDigits(m.firstPacket.Sub(m.time).Seconds(), 2, "s"))
+		}
+		rateBefore := float64(c.receivedAt(m.time)-c.receivedAt(m.time.Add(-migrationRateWindow))) / migrationRateWindow.Seconds()
+		recovered := "not recovered"
+		for _, sample := range c.receivedSamples {
+			windowStart := sample.time.Add(-migrationRateWindow)
+			if windowStart.Before(m.time) {
+				continue
+			}
+			rate := float64(sample.bytes-c.receivedAt(windowStart)) / migrationRateWindow.Seconds()
+			if rate >= migrationRecoveredShare*rateBefore {
+				recovered = fmt.Sprintf("recovered after %s", humanize.SIWithDigits(windowStart.Sub(m.time).Seconds(), 2, "s"))
+				break
+			}
+		}
+		c.logger.Infof("migration %d at second %.3f to %s: %s, received %s before, %s",
+			i+1,
+			m.time.Sub(start).Seconds(),
+			m.local,
+			firstPacket,
+			humanize.SIWithDigits(rateBefore*8, 2, "bit/s"),
+			recovered)
+	}
+}
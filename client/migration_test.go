@@ -0,0 +1,224 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"qperf-go/common"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go"
+)
+
+// recordingLogger records the info lines.
+type recordingLogger struct {
+	common.Logger
+	lines []string
+}
+
+func (l *recordingLogger) Infof(format string, args ...interface{}) {
+	l.lines = append(l.lines, fmt.Sprintf(format, args...))
+}
+
+func TestMigratingConn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	cert, err := tls.LoadX509KeyPair("../server.crt", "../server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := quic.ListenAddr("127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{common.QperfALPN}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	serverConnection := make(chan quic.Connection, 1)
+	go func() {
+		connection, err := listener.Accept(ctx)
+		if err != nil {
+			return
+		}
+		serverConnection <- connection
+		stream, err := connection.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		buf := make([]byte, 1000)
+		for {
+			_, err := stream.Write(buf)
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	conn, err := newMigratingConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	transport := &quic.Transport{Conn: conn}
+	defer transport.Close()
+	tlsConf := &tls.Config{RootCAs: common.NewCertPoolWithCert("../server.crt"), NextProtos: []string{common.QperfALPN}}
+	connection, err := transport.Dial(ctx, listener.Addr(), tlsConf, &quic.Config{KeepAlivePeriod: migrationKeepAlivePeriod})
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream, err := connection.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = stream.Write([]byte{0})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// read continuously, the ACKs sent from the new socket make the server follow
+	var received atomic.Uint64
+	go func() {
+		buf := make([]byte, 10_000)
+		for {
+			n, err := stream.Read(buf)
+			received.Add(uint64(n))
+			if err != nil {
+				return
+			}
+		}
+	}()
+	waitForBytes := func(bytes uint64) {
+		deadline := time.Now().Add(5 * time.Second)
+		for received.Load() < bytes {
+			if time.Now().After(deadline) {
+				t.Fatalf("received %d bytes, expected %d", received.Load(), bytes)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	waitForBytes(100_000)
+	oldPort := conn.LocalAddr().(*net.UDPAddr).Port
+
+	event, err := conn.migrate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPort := event.local.(*net.UDPAddr).Port
+	if newPort == oldPort {
+		t.Fatalf("migrated to the same port %d", newPort)
+	}
+	// the transfer continues on the new socket
+	waitForBytes(received.Load() + 1_000_000)
+
+	migrations := conn.history()
+	if len(migrations) != 1 || migrations[0].firstPacket.Before(migrations[0].time) {
+		t.Fatalf("unexpected migrations %+v", migrations)
+	}
+	// the server follows the new address of the client
+	if port := (<-serverConnection).RemoteAddr().(*net.UDPAddr).Port; port != newPort {
+		t.Errorf("server sends to port %d, expected %d", port, newPort)
+	}
+	_ = connection.CloseWithError(0, "")
+}
+
+func TestReceivedAt(t *testing.T) {
+	start := time.Now()
+	c := &Client{receivedSamples: []receivedSample{
+		{time: start, bytes: 100},
+		{time: start.Add(10 * time.Millisecond), bytes: 200},
+		{time: start.Add(20 * time.Millisecond), bytes: 300},
+	}}
+	for _, test := range []struct {
+		offset   time.Duration
+		expected uint64
+	}{
+		{-time.Millisecond, 0},
+		{0, 100},
+		{15 * time.Millisecond, 200},
+		{20 * time.Millisecond, 300},
+		{time.Second, 300},
+	} {
+		if got := c.receivedAt(start.Add(test.offset)); got != test.expected {
+			t.Errorf("at %s: got %d, expected %d", test.offset, got, test.expected)
+		}
+	}
+}
+
+func TestReportMigrations(t *testing.T) {
+	const migrationOffset = 500 * time.Millisecond
+	for _, test := range []struct {
+		name string
+		// the migration relative to the first byte
+		offset time.Duration
+		// no bytes are received for stall after the migration, forever if negative
+		stall       time.Duration
+		firstPacket time.Duration
+		expected    string
+	}{
+		{
+			name:        "recovered",
+			offset:      migrationOffset,
+			stall:       55 * time.Millisecond,
+			firstPacket: 30 * time.Millisecond,
+			expected:    "migration 1 at second 0.500 to 127.0.0.1:5000: first packet after 30 ms, received 800 kbit/s before, recovered after 50 ms",
+		},
+		{
+			name:     "without stall",
+			offset:   migrationOffset,
+			expected: "migration 1 at second 0.500 to 127.0.0.1:5000: no packet received, received 800 kbit/s before, recovered after 0 s",
+		},
+		{
+			name:     "not recovered",
+			offset:   migrationOffset,
+			stall:    -1,
+			expected: "migration 1 at second 0.500 to 127.0.0.1:5000: no packet received, received 800 kbit/s before, not recovered",
+		},
+		{
+			name:   "before the first byte",
+			offset: -time.Second,
+		},
+		{
+			name:   "after the end",
+			offset: 3 * time.Second,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			logger := &recordingLogger{Logger: common.DefaultLogger}
+			c := &Client{logger: logger}
+			c.state.AddReceivedBytes(1)
+			start := c.state.GetFirstByteTime()
+			c.endTime = start.Add(2 * time.Second)
+			m := migration{
+				time:  start.Add(test.offset),
+				local: &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5000},
+			}
+			if test.firstPacket != 0 {
+				m.firstPacket = m.time.Add(test.firstPacket)
+			}
+			// 1000 B every 10 ms before the migration, nothing during the stall and 950 B every 10 ms afterwards
+			var bytes uint64
+			for offset := time.Duration(0); offset <= 2*time.Second; offset += migrationSampleInterval {
+				sampleTime := start.Add(offset)
+				switch {
+				case offset == 0:
+				case !sampleTime.After(m.time):
+					bytes += 1000
+				case test.stall < 0 || !sampleTime.After(m.time.Add(test.stall)):
+				default:
+					bytes += 950
+				}
+				c.receivedSamples = append(c.receivedSamples, receivedSample{time: sampleTime, bytes: bytes})
+			}
+
+			c.reportMigrations([]migration{m})
+			if test.expected == "" {
+				if len(logger.lines) != 0 {
+					t.Errorf("unexpected report %q", logger.lines)
+				}
+				return
+			}
+			if len(logger.lines) != 1 || logger.lines[0] != test.expected {
+				t.Errorf("got %q, expected %q", logger.lines, test.expected)
+			}
+		})
+	}
+}
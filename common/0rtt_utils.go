@@ -4,19 +4,34 @@ import (
 	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
 	"github.com/apernet/quic-go"
+	"net"
+	"strings"
+	"time"
 )
 
+// maxTokenAge is how long the address tokens issued by the server are accepted.
+// quic-go documents 24 hours as the default, but the fork does not apply it and rejects every token with 0.
+const maxTokenAge = 24 * time.Hour
+
+// ListenEarly accepts QUIC connections on conn, clients can skip the address validation with the tokens of earlier connections.
+func ListenEarly(conn net.PacketConn, tlsConf *tls.Config, config *quic.Config) (*quic.EarlyListener, error) {
+	transport := &quic.Transport{Conn: conn, MaxTokenAge: maxTokenAge}
+	return transport.ListenEarly(tlsConf, config)
+}
+
 // PingToGatherSessionTicketAndToken establishes a new QUIC connection.
 // As soon as the session ticket and the token is received, the connection is closed.
-// This function can be used to prepare for 0-RTT
-// TODO add timeout
-func PingToGatherSessionTicketAndToken(addr string, tlsConf *tls.Config, config *quic.Config) error {
+// This function can be used to prepare for 0-RTT.
+// If ctx is done before both are received, the received one is stored anyway
+// and the error names the missing ones.
+func PingToGatherSessionTicketAndToken(ctx context.Context, addr string, tlsConf *tls.Config, config *quic.Config) error {
 	if tlsConf.ClientSessionCache == nil {
 		return errors.New("session cache is nil")
 	}
 	if config.TokenStore == nil {
-		panic("session cache is nil")
+		return errors.New("token store is nil")
 	}
 
 	singleSessionCache := NewSingleSessionCache()
@@ -27,17 +42,53 @@ func PingToGatherSessionTicketAndToken(addr string, tlsConf *tls.Config, config
 	tmpConfig := config.Clone()
 	tmpConfig.TokenStore = singleTokenStore
 
-	connection, err := quic.DialAddr(context.Background(), addr, tmpTlsConf, tmpConfig)
+	connection, err := quic.DialAddr(ctx, addr, tmpTlsConf, tmpConfig)
 	if err != nil {
 		return err
 	}
+	defer connection.CloseWithError(quic.ApplicationErrorCode(0), "cancel")
 
-	tlsConf.ClientSessionCache.Put(singleSessionCache.Await())
-	config.TokenStore.Put(singleTokenStore.Await())
+	return storeSessionTicketAndToken(ctx, singleSessionCache, singleTokenStore, tlsConf.ClientSessionCache, config.TokenStore)
+}
 
-	err = connection.CloseWithError(quic.ApplicationErrorCode(0), "cancel")
-	if err != nil {
-		return err
+// storeSessionTicketAndToken waits for the session ticket and the token until ctx is done and stores the received ones.
+// The error names the missing ones.
+func storeSessionTicketAndToken(ctx context.Context, singleSessionCache *SingleSessionCache, singleTokenStore *SingleTokenStore, sessionCache tls.ClientSessionCache, tokenStore quic.TokenStore) error {
+	var missing []string
+	var awaitErr error
+	sessionKey, session, err := singleSessionCache.Await(ctx)
+	if err == nil {
+		sessionCache.Put(sessionKey, session)
+	} else {
+		missing = append(missing, "session ticket")
+		awaitErr = err
+	}
+	tokenKey, token, err := singleTokenStore.Await(ctx)
+	if err == nil {
+		tokenStore.Put(tokenKey, token)
+	} else {
+		missing = append(missing, "token")
+		awaitErr = err
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("no %s received: %w", strings.Join(missing, " and "), awaitErr)
 	}
 	return nil
 }
+
+// AwaitHandshake waits until the handshake of the connection is complete.
+// HandshakeComplete is never closed if the handshake fails, the cause of the closed connection is returned then.
+func AwaitHandshake(connection quic.EarlyConnection) error {
+	select {
+	case <-connection.HandshakeComplete():
+		return nil
+	case <-connection.Context().Done():
+	}
+	// both are done if the connection was closed after the handshake
+	select {
+	case <-connection.HandshakeComplete():
+		return nil
+	default:
+		return context.Cause(connection.Context())
+	}
+}
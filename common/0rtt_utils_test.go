@@ -0,0 +1,191 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/apernet/quic-go"
+)
+
+// expiredContext is done before anything is awaited.
+func expiredContext() context.Context {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	cancel()
+	return ctx
+}
+
+func TestSingleSessionCache_Await(t *testing.T) {
+	cache := NewSingleSessionCache()
+	if _, _, err := cache.Await(expiredContext()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, expected the error of the expired context", err)
+	}
+	// nil sessions are ignored, only the first session is kept
+	cache.Put("ignored", nil)
+	session := &tls.ClientSessionState{}
+	cache.Put("example.com", session)
+	cache.Put("other.example.com", &tls.ClientSessionState{})
+	// an already received session is returned even if ctx is done
+	key, received, err := cache.Await(expiredContext())
+	if err != nil || key != "example.com" || received != session {
+		t.Fatalf("got %q, %p, %v", key, received, err)
+	}
+	if received, ok := cache.Get("example.com"); !ok || received != session {
+		t.Errorf("session not returned by Get")
+	}
+	if _, ok := cache.Get("other.example.com"); ok {
+		t.Errorf("got a session of another server")
+	}
+}
+
+func TestSingleSessionCache_AwaitWaits(t *testing.T) {
+	cache := NewSingleSessionCache()
+	session := &tls.ClientSessionState{}
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cache.Put("example.com", session)
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if _, received, err := cache.Await(ctx); err != nil || received != session {
+		t.Fatalf("got %p, %v", received, err)
+	}
+}
+
+func TestSingleTokenStore_Await(t *testing.T) {
+	store := NewSingleTokenStore()
+	if _, _, err := store.Await(expiredContext()); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("got %v, expected the error of the expired context", err)
+	}
+	token := &quic.ClientToken{}
+	store.Put("example.com", token)
+	store.Put("other.example.com", &quic.ClientToken{})
+	key, received, err := store.Await(expiredContext())
+	if err != nil || key != "example.com" || received != token {
+		t.Fatalf("got %q, %p, %v", key, received, err)
+	}
+	// Pop does not remove the token
+	for i := 0; i < 2; i++ {
+		if store.Pop("example.com") != token {
+			t.Errorf("token not returned by Pop")
+		}
+	}
+	if store.Pop("other.example.com") != nil {
+		t.Errorf("got a token of another server")
+	}
+}
+
+func TestStoreSessionTicketAndToken(t *testing.T) {
+	for _, test := range []struct {
+		name                  string
+		sessionTicket, token  bool
+		expectedMissingPhrase string
+	}{
+		{name: "both", sessionTicket: true, token: true},
+		{name: "no session ticket", token: true, expectedMissingPhrase: "no session ticket received"},
+		{name: "no token", sessionTicket: true, expectedMissingPhrase: "no token received"},
+		{name: "neither", expectedMissingPhrase: "no session ticket and token received"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			singleSessionCache := NewSingleSessionCache()
+			singleTokenStore := NewSingleTokenStore()
+			session := &tls.ClientSessionState{}
+			token := (*quic.ClientToken)(unsafe.Pointer(&clientToken{data: []byte("token")}))
+			if test.sessionTicket {
+				singleSessionCache.Put("example.com", session)
+			}
+			if test.token {
+				singleTokenStore.Put("example.com", token)
+			}
+			sessionCache := tls.NewLRUClientSessionCache(1)
+			tokenStore := quic.NewLRUTokenStore(1, 1)
+
+			err := storeSessionTicketAndToken(expiredContext(), singleSessionCache, singleTokenStore, sessionCache, tokenStore)
+			if test.expectedMissingPhrase == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+			} else {
+				if err == nil || !strings.HasPrefix(err.Error(), test.expectedMissingPhrase+":") {
+					t.Fatalf("got %v, expected %q", err, test.expectedMissingPhrase)
+				}
+				if !errors.Is(err, context.DeadlineExceeded) {
+					t.Errorf("got %v, expected the error of the expired context", err)
+				}
+			}
+			// the received ones are stored anyway
+			if received, ok := sessionCache.Get("example.com"); ok != test.sessionTicket || (ok && received != session) {
+				t.Errorf("session stored: %t, expected %t", ok, test.sessionTicket)
+			}
+			if received := tokenStore.Pop("example.com"); (received != nil) != test.token {
+				t.Errorf("token stored: %t, expected %t", received != nil, test.token)
+			}
+		})
+	}
+}
+
+// listenLoopback accepts connections with the test certificate until the test ends.
+func listenLoopback(t *testing.T, config *quic.Config) string {
+	cert, err := tls.LoadX509KeyPair("../server.crt", "../server.key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf := &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: []string{QperfALPN}}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the listener of the server
+	listener, err := ListenEarly(conn, tlsConf, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = listener.Close()
+		_ = conn.Close()
+	})
+	go func() {
+		for {
+			_, err := listener.Accept(context.Background())
+			if err != nil {
+				return
+			}
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func TestPingToGatherSessionTicketAndToken(t *testing.T) {
+	addr := listenLoopback(t, nil)
+	tlsConf := &tls.Config{
+		RootCAs:            NewCertPoolWithCert("../server.crt"),
+		NextProtos:         []string{QperfALPN},
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	config := &quic.Config{TokenStore: quic.NewLRUTokenStore(1, 1)}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := PingToGatherSessionTicketAndToken(ctx, addr, tlsConf, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the session ticket resumes the next connection
+	connection, err := quic.DialAddrEarly(ctx, addr, tlsConf, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer connection.CloseWithError(0, "")
+	err = AwaitHandshake(connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !connection.ConnectionState().TLS.DidResume {
+		t.Error("the session ticket was not used")
+	}
+}
@@ -2,6 +2,8 @@ package common
 
 import (
 	"errors"
+	"fmt"
+	"math"
 	"regexp"
 	"strconv"
 	"strings"
@@ -22,33 +24,79 @@ func ParseByteCountWithUnit(s string) (uint64, error) {
 	if err != nil {
 		return 0, err
 	}
-	suffix := strings.TrimSpace(strings.ToLower(match[2]))
+	multiplier, err := byteUnitMultiplier(strings.TrimSpace(strings.ToLower(match[2])))
+	if err != nil {
+		return 0, err
+	}
+	return number * multiplier, nil
+}
+
+// byteUnitMultiplier returns the bytes of a lower case unit suffix of ParseByteCountWithUnit.
+func byteUnitMultiplier(suffix string) (uint64, error) {
 	switch suffix {
-	case "":
-		return number, nil
-	case "b":
-		return number, nil
+	case "", "b":
+		return 1, nil
 	case "kb":
-		return number * 1e3, nil
+		return 1e3, nil
 	case "mb":
-		return number * 1e6, nil
+		return 1e6, nil
 	case "gb":
-		return number * 1e9, nil
+		return 1e9, nil
 	case "tb":
-		return number * 1e12, nil
+		return 1e12, nil
 	case "pb":
-		return number * 1e15, nil
+		return 1e15, nil
 	case "kib":
-		return number * (1 << 10), nil
+		return 1 << 10, nil
 	case "mib":
-		return number * (1 << 20), nil
+		return 1 << 20, nil
 	case "gib":
-		return number * (1 << 30), nil
+		return 1 << 30, nil
 	case "tib":
-		return number * (1 << 40), nil
+		return 1 << 40, nil
 	case "pib":
-		return number * (1 << 50), nil
+		return 1 << 50, nil
 	default:
 		return 0, errors.New("invalid suffix")
 	}
 }
+
+// ParseBandwidth parses a bandwidth and returns it in bytes per second.
+// It supports the 10^3 based bit rate suffixes bps, kbps, mbps, gbps, tbps,
+// all suffixes of ParseByteCountWithUnit are interpreted as bytes per second, optionally followed by /s.
+// the unit suffix is case-insensitive.
+// the number may have a fraction, e.g. 1.5Gbps, the result is rounded to whole bytes per second.
+func ParseBandwidth(s string) (uint64, error) {
+	expr := regexp.MustCompile("^(\\d+(?:\\.\\d+)?)\\s*([a-zA-Z]*)(/s)?$")
+	match := expr.FindStringSubmatch(strings.TrimSpace(s))
+	if len(match) != 4 {
+		return 0, fmt.Errorf("failed to parse bandwidth: %q", s)
+	}
+	number, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	var bytesPerSecond float64
+	switch suffix := strings.ToLower(match[2]); suffix {
+	case "bps":
+		bytesPerSecond = number / 8
+	case "kbps":
+		bytesPerSecond = number * 1e3 / 8
+	case "mbps":
+		bytesPerSecond = number * 1e6 / 8
+	case "gbps":
+		bytesPerSecond = number * 1e9 / 8
+	case "tbps":
+		bytesPerSecond = number * 1e12 / 8
+	default:
+		multiplier, err := byteUnitMultiplier(suffix)
+		if err != nil {
+			return 0, err
+		}
+		bytesPerSecond = number * float64(multiplier)
+	}
+	if bytesPerSecond >= math.MaxUint64 {
+		return 0, fmt.Errorf("bandwidth out of range: %q", s)
+	}
+	return uint64(math.Round(bytesPerSecond)), nil
+}
@@ -0,0 +1,40 @@
+package common
+
+import "testing"
+
+func TestParseBandwidth(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		expected uint64
+	}{
+		{"0", 0},
+		{"1000", 1000},
+		{"100Mbps", 12.5e6},
+		{"100 mbps", 12.5e6},
+		{"8bps", 1},
+		{"64kbps", 8e3},
+		{"1.5Gbps", 187.5e6},
+		{"1Tbps", 125e9},
+		{"10MB", 10e6},
+		{"10MB/s", 10e6},
+		{"2KiB/s", 2048},
+		{"1.5MiB", 1.5 * (1 << 20)},
+		{" 20Mbps ", 2.5e6},
+		{"0.5", 1},
+	} {
+		got, err := ParseBandwidth(test.s)
+		if err != nil {
+			t.Errorf("%q: %s", test.s, err)
+		} else if got != test.expected {
+			t.Errorf("%q: got %d, expected %d", test.s, got, test.expected)
+		}
+	}
+}
+
+func TestParseBandwidthInvalid(t *testing.T) {
+	for _, s := range []string{"", "abc 7kbps", "7kbps abc", "1.5.0Gbps", ".5Mbps", "-1Mbps", "10Mbit", "10 MB /s", "1e3bps", "100000000000000000000Gbps"} {
+		if got, err := ParseBandwidth(s); err == nil {
+			t.Errorf("%q: got %d, expected an error", s, got)
+		}
+	}
+}
@@ -11,6 +11,11 @@ const RuntimeReachedErrorCode = quic.ApplicationErrorCode(0)
 
 const (
 	CC_CUBIC  = "cubic"
+	CC_RENO   = "reno"
 	CC_RL     = "rl"
 	CC_BRUTAL = "brutal"
+	CC_BBR    = "bbr"
+
+	// rl with a frozen policy or a replayed episode, without an external agent
+	CC_RL_STATIC = "rl-static"
 )
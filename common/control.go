@@ -0,0 +1,102 @@
+package common
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// ControlProtocolVersion is the version of the qperf control protocol.
+// It has to be increased on every incompatible change of the control messages.
+const ControlProtocolVersion = 1
+
+// MaxControlMessageLength is the maximum length of the JSON body of a control message.
+const MaxControlMessageLength = 1 << 16
+
+// controlMessageHeaderLength is the length of version, type and body length.
+const controlMessageHeaderLength = 6
+
+type ControlMessageType uint8
+
+const (
+	// ControlMessageHello is sent by the client on the first stream and contains the TestParameters
+	ControlMessageHello ControlMessageType = 1 + iota
+	// ControlMessageHelloAck is the response of the server to ControlMessageHello and contains the ServerCapabilities
+	ControlMessageHelloAck
+	// ControlMessageStreamRequest is sent by the client at the beginning of every data stream
+	ControlMessageStreamRequest
+	// ControlMessageReceiveReport is sent by the server on upload streams
+	ControlMessageReceiveReport
+)
+
+func (t ControlMessageType) String() string {
+	switch t {
+	case ControlMessageHello:
+		return "hello"
+	case ControlMessageHelloAck:
+		return "hello ack"
+	case ControlMessageStreamRequest:
+		return "stream request"
+	case ControlMessageReceiveReport:
+		return "receive report"
+	default:
+		return fmt.Sprintf("unknown control message type: %d", t)
+	}
+}
+
+// ControlVersionError is returned when the peer uses an unsupported version of the control protocol.
+type ControlVersionError struct {
+	Version uint8
+}
+
+func (e *ControlVersionError) Error() string {
+	return fmt.Sprintf("unsupported control protocol version %d, expected %d", e.Version, ControlProtocolVersion)
+}
+
+// WriteControlMessage writes a control message.
+// A control message consists of the protocol version (1 byte), the message type (1 byte),
+// the length of the body (4 bytes, big endian) and the JSON encoded body.
+func WriteControlMessage(w io.Writer, messageType ControlMessageType, message any) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+	if len(body) > MaxControlMessageLength {
+		return fmt.Errorf("control message too long: %d bytes", len(body))
+	}
+	buf := make([]byte, controlMessageHeaderLength, controlMessageHeaderLength+len(body))
+	buf[0] = ControlProtocolVersion
+	buf[1] = byte(messageType)
+	binary.BigEndian.PutUint32(buf[2:], uint32(len(body)))
+	buf = append(buf, body...)
+	_, err = w.Write(buf)
+	return err
+}
+
+// ReadControlMessage reads a control message of the expected type and decodes its body into message.
+// Only the bytes of the control message are read, so the data that follows can be read from r afterwards.
+func ReadControlMessage(r io.Reader, expectedType ControlMessageType, message any) error {
+	header := make([]byte, controlMessageHeaderLength)
+	_, err := io.ReadFull(r, header)
+	if err != nil {
+		return err
+	}
+	if header[0] != ControlProtocolVersion {
+		return &ControlVersionError{Version: header[0]}
+	}
+	messageType := ControlMessageType(header[1])
+	if messageType != expectedType {
+		return fmt.Errorf("unexpected control message: got %s, expected %s", messageType, expectedType)
+	}
+	length := binary.BigEndian.Uint32(header[2:])
+	if length > MaxControlMessageLength {
+		return fmt.Errorf("control message too long: %d bytes", length)
+	}
+	body := make([]byte, length)
+	_, err = io.ReadFull(r, body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, message)
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestControlMessage_RoundTrip(t *testing.T) {
+	buf := &bytes.Buffer{}
+	parameters := TestParameters{
+		Direction: DirectionBidirectional,
+		Duration:  10 * time.Second,
+		BlockSize: DefaultBlockSize,
+		Streams:   4,
+	}
+	err := WriteControlMessage(buf, ControlMessageHello, &parameters)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// data following the control message must not be consumed
+	buf.WriteString("data")
+
+	received := TestParameters{}
+	err = ReadControlMessage(buf, ControlMessageHello, &received)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != parameters {
+		t.Fatalf("expected %+v, got %+v", parameters, received)
+	}
+	if buf.String() != "data" {
+		t.Fatalf("expected remaining data, got %q", buf.String())
+	}
+}
+
+func TestControlMessage_UnexpectedType(t *testing.T) {
+	buf := &bytes.Buffer{}
+	err := WriteControlMessage(buf, ControlMessageStreamRequest, &StreamRequest{Direction: DirectionUpload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ReadControlMessage(buf, ControlMessageHello, &TestParameters{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestControlMessage_UnsupportedVersion(t *testing.T) {
+	buf := bytes.NewBufferString("qperf start sending")
+	err := ReadControlMessage(buf, ControlMessageHello, &TestParameters{})
+	var versionErr *ControlVersionError
+	if !errors.As(err, &versionErr) {
+		t.Fatalf("expected version error, got %v", err)
+	}
+}
+
+func TestControlMessage_TooLong(t *testing.T) {
+	buf := bytes.NewBuffer([]byte{ControlProtocolVersion, byte(ControlMessageHello), 0xff, 0xff, 0xff, 0xff})
+	err := ReadControlMessage(buf, ControlMessageHello, &TestParameters{})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
@@ -0,0 +1,67 @@
+package common
+
+import (
+	"context"
+	"github.com/apernet/quic-go"
+	"github.com/apernet/quic-go/logging"
+	"sync"
+	"sync/atomic"
+)
+
+// ECNCounter counts the ECN-CE marks reported by the peer in ACK frames of a connection.
+type ECNCounter struct {
+	ce atomic.Uint64
+}
+
+// ECNCE returns the number of packets the peer received with an ECN-CE mark.
+func (c *ECNCounter) ECNCE() uint64 {
+	return c.ce.Load()
+}
+
+// ECNCounters creates an ECNCounter for every connection traced by NewConnectionTracer.
+type ECNCounters struct {
+	counters sync.Map
+}
+
+// NewConnectionTracer can be used as quic.Config.Tracer.
+func (e *ECNCounters) NewConnectionTracer(ctx context.Context, _ logging.Perspective, _ logging.ConnectionID) *logging.ConnectionTracer {
+	tracingID, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+	counter := &ECNCounter{}
+	e.counters.Store(tracingID, counter)
+	return &logging.ConnectionTracer{
+		ReceivedShortHeaderPacket: func(_ *logging.ShortHeader, _ logging.ByteCount, _ logging.ECN, frames []logging.Frame) {
+			for _, frame := range frames {
+				ack, ok := frame.(*logging.AckFrame)
+				if !ok {
+					continue
+				}
+				// the count is cumulative, ACK frames may be reordered
+				for {
+					ce := counter.ce.Load()
+					if ack.ECNCE <= ce || counter.ce.CompareAndSwap(ce, ack.ECNCE) {
+						break
+					}
+				}
+			}
+		},
+		Close: func() {
+			e.counters.Delete(tracingID)
+		},
+	}
+}
+
+// Get returns the ECNCounter of the connection with the context ctx, or nil if the connection is not traced.
+func (e *ECNCounters) Get(ctx context.Context) *ECNCounter {
+	tracingID, ok := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return nil
+	}
+	counter, ok := e.counters.Load(tracingID)
+	if !ok {
+		return nil
+	}
+	return counter.(*ECNCounter)
+}
@@ -1,54 +1,192 @@
 package common
 
 import (
+	"context"
+	"github.com/apernet/quic-go"
 	"github.com/apernet/quic-go/logging"
 	"net"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
-type eventTracer struct {
-	logging.Tracer
-	handlers Handlers
-}
-
+// Handlers are called by the EventTracer for the events of a connection, nil handlers are skipped.
 type Handlers struct {
 	StartedConnection func(odcid logging.ConnectionID, local, remote net.Addr, srcConnID, destConnID logging.ConnectionID)
-	UpdatePath        func(odcid logging.ConnectionID, newRemote net.Addr)
-	ClosedConnection  func(odcid logging.ConnectionID, err error)
+	// the handshake is confirmed, the handshake keys are dropped
+	CompletedHandshake func(odcid logging.ConnectionID)
+	// the remote address of a connection passed to EventTracer.Watch changed
+	UpdatePath func(odcid logging.ConnectionID, newRemote net.Addr)
+	UpdatedKey func(odcid logging.ConnectionID, generation logging.KeyPhase, remote bool)
+	// a path MTU probe of mtu bytes was acknowledged
+	UpdatedMTU       func(odcid logging.ConnectionID, mtu logging.ByteCount)
+	ClosedConnection func(odcid logging.ConnectionID, err error)
 }
 
-// func NewEventTracer(handlers Handlers) logging.Tracer {
-// 	return &eventTracer{
-// 		handlers: handlers,
-// 	}
-// }
+// EventTracer reports the events of all connections traced by NewConnectionTracer to its Handlers.
+// It composes with other tracers, e.g. qlog, see NewMultiplexedConnectionTracer.
+type EventTracer struct {
+	handlers    Handlers
+	connections sync.Map
+}
 
-// func (a eventTracer) TracerForConnection(ctx context.Context, p logging.Perspective, odcid logging.ConnectionID) logging.ConnectionTracer {
-// 	return connectionEventTracer{
-// 		odcid:   odcid,
-// 		handers: a.handlers,
-// 	}
-// }
+func NewEventTracer(handlers Handlers) *EventTracer {
+	return &EventTracer{
+		handlers: handlers,
+	}
+}
 
 type connectionEventTracer struct {
-	logging.ConnectionTracer
-	odcid   logging.ConnectionID
-	handers Handlers
+	odcid    logging.ConnectionID
+	handlers *Handlers
+	// set by EventTracer.Watch
+	connection atomic.Pointer[quic.Connection]
+	remote     net.Addr
+	// largest datagram size acknowledged or sent without probing
+	mtu logging.ByteCount
+	// sizes of the path MTU probes in flight by packet number
+	mtuProbes map[logging.PacketNumber]logging.ByteCount
+}
+
+// NewConnectionTracer can be used as quic.Config.Tracer.
+func (e *EventTracer) NewConnectionTracer(ctx context.Context, _ logging.Perspective, odcid logging.ConnectionID) *logging.ConnectionTracer {
+	c := &connectionEventTracer{
+		odcid:     odcid,
+		handlers:  &e.handlers,
+		mtuProbes: make(map[logging.PacketNumber]logging.ByteCount),
+	}
+	tracingID, traced := ctx.Value(quic.ConnectionTracingKey).(uint64)
+	if traced {
+		e.connections.Store(tracingID, c)
+	}
+	// all callbacks but Watch are called by the run loop of the connection
+	return &logging.ConnectionTracer{
+		StartedConnection: c.startedConnection,
+		ReceivedShortHeaderPacket: func(*logging.ShortHeader, logging.ByteCount, logging.ECN, []logging.Frame) {
+			c.checkPath()
+		},
+		SentShortHeaderPacket: c.sentShortHeaderPacket,
+		AcknowledgedPacket:    c.acknowledgedPacket,
+		LostPacket: func(_ logging.EncryptionLevel, pn logging.PacketNumber, _ logging.PacketLossReason) {
+			delete(c.mtuProbes, pn)
+		},
+		UpdatedKey: func(generation logging.KeyPhase, remote bool) {
+			if c.handlers.UpdatedKey != nil {
+				c.handlers.UpdatedKey(c.odcid, generation, remote)
+			}
+		},
+		DroppedEncryptionLevel: func(level logging.EncryptionLevel) {
+			if level == logging.EncryptionHandshake && c.handlers.CompletedHandshake != nil {
+				c.handlers.CompletedHandshake(c.odcid)
+			}
+		},
+		ClosedConnection: func(err error) {
+			if c.handlers.ClosedConnection != nil {
+				c.handlers.ClosedConnection(c.odcid, err)
+			}
+		},
+		Close: func() {
+			if traced {
+				e.connections.Delete(tracingID)
+			}
+		},
+	}
 }
 
-func (c connectionEventTracer) StartedConnection(local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
-	if c.handers.StartedConnection != nil {
-		c.handers.StartedConnection(c.odcid, local, remote, srcConnID, destConnID)
+// Watch reports changes of the remote address of the connection to Handlers.UpdatePath.
+// quic-go does not trace path changes, so the address is compared whenever a packet is received,
+// a change is noticed with the packet after the first one from the new address.
+func (e *EventTracer) Watch(connection quic.Connection) {
+	tracingID, ok := connection.Context().Value(quic.ConnectionTracingKey).(uint64)
+	if !ok {
+		return
+	}
+	if c, ok := e.connections.Load(tracingID); ok {
+		c.(*connectionEventTracer).connection.Store(&connection)
 	}
 }
 
-func (c connectionEventTracer) UpdatedPath(newRemote net.Addr) {
-	if c.handers.UpdatePath != nil {
-		c.handers.UpdatePath(c.odcid, newRemote)
+func (c *connectionEventTracer) startedConnection(local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
+	c.remote = remote
+	if c.handlers.StartedConnection != nil {
+		c.handlers.StartedConnection(c.odcid, local, remote, srcConnID, destConnID)
 	}
 }
 
-func (c connectionEventTracer) ClosedConnection(err error) {
-	if c.handers.ClosedConnection != nil {
-		c.handers.ClosedConnection(c.odcid, err)
+func (c *connectionEventTracer) checkPath() {
+	connection := c.connection.Load()
+	if connection == nil {
+		return
+	}
+	remote := (*connection).RemoteAddr()
+	if c.remote != nil && remote.String() == c.remote.String() {
+		return
+	}
+	c.remote = remote
+	if c.handlers.UpdatePath != nil {
+		c.handlers.UpdatePath(c.odcid, remote)
+	}
+}
+
+// sentShortHeaderPacket remembers the path MTU probes, which are PING only packets larger than the current MTU.
+func (c *connectionEventTracer) sentShortHeaderPacket(hdr *logging.ShortHeader, size logging.ByteCount, _ logging.ECN, ack *logging.AckFrame, frames []logging.Frame) {
+	if size <= c.mtu {
+		return
+	}
+	if ack == nil && len(frames) == 1 {
+		if _, ping := frames[0].(*logging.PingFrame); ping {
+			c.mtuProbes[hdr.PacketNumber] = size
+			return
+		}
+	}
+	c.mtu = size
+}
+
+func (c *connectionEventTracer) acknowledgedPacket(level logging.EncryptionLevel, pn logging.PacketNumber) {
+	if level != logging.Encryption1RTT {
+		return
+	}
+	size, ok := c.mtuProbes[pn]
+	if !ok {
+		return
+	}
+	delete(c.mtuProbes, pn)
+	if size <= c.mtu {
+		return
+	}
+	c.mtu = size
+	if c.handlers.UpdatedMTU != nil {
+		c.handlers.UpdatedMTU(c.odcid, size)
+	}
+}
+
+// LogEvents returns Handlers logging all events, with the time elapsed since the start of the connection.
+func LogEvents(logger Logger, elapsed func(odcid logging.ConnectionID) time.Duration) Handlers {
+	logf := func(odcid logging.ConnectionID, format string, args ...any) {
+		logger.Infof("%.3f s: "+format, append([]any{elapsed(odcid).Seconds()}, args...)...)
+	}
+	return Handlers{
+		StartedConnection: func(odcid logging.ConnectionID, local, remote net.Addr, _, _ logging.ConnectionID) {
+			logf(odcid, "started QUIC connection %s from %s to %s", odcid, local, remote)
+		},
+		CompletedHandshake: func(odcid logging.ConnectionID) {
+			logf(odcid, "handshake of QUIC connection %s confirmed", odcid)
+		},
+		UpdatePath: func(odcid logging.ConnectionID, newRemote net.Addr) {
+			logf(odcid, "migrated QUIC connection %s to %s", odcid, newRemote)
+		},
+		UpdatedKey: func(odcid logging.ConnectionID, generation logging.KeyPhase, remote bool) {
+			initiator := "local"
+			if remote {
+				initiator = "remote"
+			}
+			logf(odcid, "updated keys of QUIC connection %s to generation %d (%s)", odcid, generation, initiator)
+		},
+		UpdatedMTU: func(odcid logging.ConnectionID, mtu logging.ByteCount) {
+			logf(odcid, "MTU of QUIC connection %s is %d bytes", odcid, mtu)
+		},
+		ClosedConnection: func(odcid logging.ConnectionID, err error) {
+			logf(odcid, "closed QUIC connection %s: %v", odcid, err)
+		},
 	}
 }
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"context"
+	"testing"
+
+	"github.com/apernet/quic-go/logging"
+)
+
+func TestEventTracer(t *testing.T) {
+	var mtus []logging.ByteCount
+	var handshakes, keyUpdates int
+	tracer := NewEventTracer(Handlers{
+		CompletedHandshake: func(logging.ConnectionID) { handshakes++ },
+		UpdatedKey:         func(logging.ConnectionID, logging.KeyPhase, bool) { keyUpdates++ },
+		UpdatedMTU:         func(_ logging.ConnectionID, mtu logging.ByteCount) { mtus = append(mtus, mtu) },
+	}).NewConnectionTracer(context.Background(), logging.PerspectiveClient, logging.ConnectionID{})
+
+	tracer.DroppedEncryptionLevel(logging.EncryptionInitial)
+	tracer.DroppedEncryptionLevel(logging.EncryptionHandshake)
+	tracer.UpdatedKey(1, true)
+	ping := []logging.Frame{&logging.PingFrame{}}
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: 1}, 1200, logging.ECNUnsupported, nil, []logging.Frame{&logging.StreamFrame{}})
+	// the probes of 1300 and 1400 bytes are acknowledged, the one of 1450 bytes is lost
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: 2}, 1300, logging.ECNUnsupported, nil, ping)
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: 3}, 1400, logging.ECNUnsupported, nil, ping)
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: 4}, 1450, logging.ECNUnsupported, nil, ping)
+	// a keep-alive PING is smaller than the MTU
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{PacketNumber: 5}, 40, logging.ECNUnsupported, nil, ping)
+	tracer.AcknowledgedPacket(logging.Encryption1RTT, 1)
+	tracer.AcknowledgedPacket(logging.Encryption1RTT, 3)
+	tracer.AcknowledgedPacket(logging.Encryption1RTT, 2)
+	tracer.LostPacket(logging.Encryption1RTT, 4, logging.PacketLossTimeThreshold)
+	tracer.AcknowledgedPacket(logging.Encryption1RTT, 5)
+
+	if handshakes != 1 || keyUpdates != 1 {
+		t.Errorf("got %d handshakes and %d key updates", handshakes, keyUpdates)
+	}
+	if len(mtus) != 1 || mtus[0] != 1400 {
+		t.Errorf("got MTUs %v, expected [1400]", mtus)
+	}
+}
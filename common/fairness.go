@@ -0,0 +1,16 @@
+package common
+
+// JainsFairnessIndex calculates Jain's fairness index of the values.
+// The result is between 1/n (unfair) and 1 (fair).
+// See https://en.wikipedia.org/wiki/Fairness_measure
+func JainsFairnessIndex(values []float64) float64 {
+	var sum, sumOfSquares float64
+	for _, value := range values {
+		sum += value
+		sumOfSquares += value * value
+	}
+	if sumOfSquares == 0 {
+		return 1
+	}
+	return sum * sum / (float64(len(values)) * sumOfSquares)
+}
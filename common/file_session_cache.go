@@ -0,0 +1,151 @@
+package common
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// FileSessionCache is a tls.ClientSessionCache storing the latest session ticket of every server name in a directory,
+// so tickets can be used by later runs, e.g. for 0-RTT.
+// Expired tickets are kept, crypto/tls does not use them.
+type FileSessionCache struct {
+	mutex  sync.Mutex
+	dir    string
+	logger Logger
+}
+
+var _ tls.ClientSessionCache = (*FileSessionCache)(nil)
+
+// storedSession is the file of a session ticket.
+type storedSession struct {
+	Ticket []byte `json:"ticket"`
+	// tls.SessionState, including the QUIC transport parameters stored by quic-go
+	State    []byte    `json:"state"`
+	Received time.Time `json:"received"`
+}
+
+func NewFileSessionCache(dir string, logger Logger) (*FileSessionCache, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileSessionCache{dir: dir, logger: logger}, nil
+}
+
+func (c *FileSessionCache) Get(sessionKey string) (*tls.ClientSessionState, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stored, err := c.load(sessionKey)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			c.logger.Errorf("failed to load session ticket of %s: %s", sessionKey, err)
+		}
+		return nil, false
+	}
+	state, err := tls.ParseSessionState(stored.State)
+	if err != nil {
+		c.logger.Errorf("failed to parse session ticket of %s: %s", sessionKey, err)
+		return nil, false
+	}
+	session, err := tls.NewResumptionState(stored.Ticket, state)
+	if err != nil {
+		c.logger.Errorf("failed to restore session ticket of %s: %s", sessionKey, err)
+		return nil, false
+	}
+	return session, true
+}
+
+// Put replaces the session ticket of sessionKey, a nil session removes it.
+func (c *FileSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	path := sessionFileName(c.dir, sessionKey, "ticket")
+	if cs == nil {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			c.logger.Errorf("failed to remove session ticket of %s: %s", sessionKey, err)
+		}
+		return
+	}
+	err := func() error {
+		ticket, state, err := cs.ResumptionState()
+		if err != nil {
+			return err
+		}
+		stateBytes, err := state.Bytes()
+		if err != nil {
+			return err
+		}
+		return writeJSONFile(path, &storedSession{
+			Ticket:   ticket,
+			State:    stateBytes,
+			Received: time.Now(),
+		})
+	}()
+	if err != nil {
+		c.logger.Errorf("failed to store session ticket of %s: %s", sessionKey, err)
+	}
+}
+
+// Received returns the time the stored session ticket of sessionKey was received, false if there is none.
+func (c *FileSessionCache) Received(sessionKey string) (time.Time, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	stored, err := c.load(sessionKey)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return stored.Received, true
+}
+
+func (c *FileSessionCache) load(sessionKey string) (*storedSession, error) {
+	stored := &storedSession{}
+	err := readJSONFile(sessionFileName(c.dir, sessionKey, "ticket"), stored)
+	if err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// sessionFileName returns the file of the key in dir, the key is escaped to be a valid file name.
+func sessionFileName(dir string, key string, extension string) string {
+	return filepath.Join(dir, url.PathEscape(key)+"."+extension)
+}
+
+func readJSONFile(path string, v any) error {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(body, v)
+}
+
+// writeJSONFile replaces the file atomically, so concurrent runs do not read partial files.
+func writeJSONFile(path string, v any) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(body)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+	}
+	return err
+}
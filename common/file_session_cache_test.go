@@ -0,0 +1,74 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go"
+)
+
+func TestFileSessionCache(t *testing.T) {
+	addr := listenLoopback(t, nil)
+	dir := t.TempDir()
+	cache, err := NewFileSessionCache(dir, DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf := &tls.Config{
+		RootCAs:            NewCertPoolWithCert("../server.crt"),
+		NextProtos:         []string{QperfALPN},
+		ClientSessionCache: cache,
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = PingToGatherSessionTicketAndToken(ctx, addr, tlsConf, &quic.Config{TokenStore: quic.NewLRUTokenStore(1, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a later run resumes the session with the stored ticket
+	tlsConf.ClientSessionCache, err = NewFileSessionCache(dir, DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection, err := quic.DialAddrEarly(ctx, addr, tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = AwaitHandshake(connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = connection.CloseWithError(0, "")
+	if !connection.ConnectionState().TLS.DidResume {
+		t.Fatal("the stored session ticket was not used")
+	}
+}
+
+func TestFileSessionCache_PutNilRemoves(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := NewFileSessionCache(dir, DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := sessionFileName(dir, "example.com", "ticket")
+	err = writeJSONFile(path, &storedSession{Received: time.Now()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Received("example.com"); !ok {
+		t.Fatal("stored session ticket not found")
+	}
+	cache.Put("example.com", nil)
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("session ticket file not removed: %v", err)
+	}
+	if _, ok := cache.Get("example.com"); ok {
+		t.Error("got a removed session ticket")
+	}
+	// removing a missing session ticket is not an error
+	cache.Put("example.com", nil)
+}
@@ -0,0 +1,76 @@
+package common
+
+import (
+	"errors"
+	"github.com/apernet/quic-go"
+	"io/fs"
+	"os"
+	"sync"
+	"time"
+	"unsafe"
+)
+
+// FileTokenStore is a quic.TokenStore storing the latest address token of every server name in a directory,
+// so tokens can be used by later runs.
+type FileTokenStore struct {
+	mutex  sync.Mutex
+	dir    string
+	logger Logger
+}
+
+var _ quic.TokenStore = (*FileTokenStore)(nil)
+
+// clientToken has the layout of quic.ClientToken, whose token is not exported.
+// It is tied to the quic-go fork pinned in go.mod, TestClientTokenLayout checks it.
+type clientToken struct {
+	data []byte
+}
+
+// fails to compile if the size of quic.ClientToken changes
+var _ = [1]struct{}{}[unsafe.Sizeof(quic.ClientToken{})-unsafe.Sizeof(clientToken{})]
+
+// storedToken is the file of an address token.
+type storedToken struct {
+	Token    []byte    `json:"token"`
+	Received time.Time `json:"received"`
+}
+
+func NewFileTokenStore(dir string, logger Logger) (*FileTokenStore, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	return &FileTokenStore{dir: dir, logger: logger}, nil
+}
+
+// Pop removes the token, tokens are not supposed to be reused.
+func (s *FileTokenStore) Pop(key string) *quic.ClientToken {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	path := sessionFileName(s.dir, key, "token")
+	stored := &storedToken{}
+	err := readJSONFile(path, stored)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			s.logger.Errorf("failed to load token of %s: %s", key, err)
+		}
+		return nil
+	}
+	err = os.Remove(path)
+	if err != nil {
+		s.logger.Errorf("failed to remove token of %s: %s", key, err)
+	}
+	return (*quic.ClientToken)(unsafe.Pointer(&clientToken{data: stored.Token}))
+}
+
+func (s *FileTokenStore) Put(key string, token *quic.ClientToken) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	err := writeJSONFile(sessionFileName(s.dir, key, "token"), &storedToken{
+		Token:    (*clientToken)(unsafe.Pointer(token)).data,
+		Received: time.Now(),
+	})
+	if err != nil {
+		s.logger.Errorf("failed to store token of %s: %s", key, err)
+	}
+}
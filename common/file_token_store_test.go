@@ -0,0 +1,97 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/apernet/quic-go"
+)
+
+// TestClientTokenLayout fails if quic.ClientToken no longer has the layout of clientToken.
+func TestClientTokenLayout(t *testing.T) {
+	expected := reflect.TypeOf(clientToken{})
+	actual := reflect.TypeOf(quic.ClientToken{})
+	if actual.NumField() != expected.NumField() || actual.Size() != expected.Size() {
+		t.Fatalf("quic.ClientToken has %d fields and %d bytes, expected %d and %d", actual.NumField(), actual.Size(), expected.NumField(), expected.Size())
+	}
+	for i := 0; i < expected.NumField(); i++ {
+		e, a := expected.Field(i), actual.Field(i)
+		if a.Name != e.Name || a.Type != e.Type || a.Offset != e.Offset {
+			t.Errorf("field %d of quic.ClientToken is %s %s at %d, expected %s %s at %d", i, a.Name, a.Type, a.Offset, e.Name, e.Type, e.Offset)
+		}
+	}
+}
+
+func TestFileTokenStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewFileTokenStore(dir, DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Put("example.com", (*quic.ClientToken)(unsafe.Pointer(&clientToken{data: []byte("token")})))
+
+	// a later run reads the token of the same server name once
+	store, err = NewFileTokenStore(dir, DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token := store.Pop("other.example.com"); token != nil {
+		t.Errorf("got a token of another server")
+	}
+	token := store.Pop("example.com")
+	if token == nil || string((*clientToken)(unsafe.Pointer(token)).data) != "token" {
+		t.Fatalf("got token %v", token)
+	}
+	if token := store.Pop("example.com"); token != nil {
+		t.Errorf("token was not removed")
+	}
+}
+
+// TestFileTokenStore_Dial checks that the listener of the server accepts the stored tokens.
+func TestFileTokenStore_Dial(t *testing.T) {
+	// only connections without a valid token are asked for
+	var withoutToken atomic.Int32
+	addr := listenLoopback(t, &quic.Config{RequireAddressValidation: func(net.Addr) bool {
+		withoutToken.Add(1)
+		return false
+	}})
+	dir := t.TempDir()
+	store, err := NewFileTokenStore(dir, DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf := &tls.Config{
+		RootCAs:            NewCertPoolWithCert("../server.crt"),
+		NextProtos:         []string{QperfALPN},
+		ClientSessionCache: tls.NewLRUClientSessionCache(1),
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err = PingToGatherSessionTicketAndToken(ctx, addr, tlsConf, &quic.Config{TokenStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withoutToken.Load() != 1 {
+		t.Fatalf("%d connections without token, expected 1", withoutToken.Load())
+	}
+
+	// a later run validates its address with the stored token
+	store, err = NewFileTokenStore(dir, DefaultLogger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection, err := quic.DialAddr(ctx, addr, tlsConf, &quic.Config{TokenStore: store})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = connection.CloseWithError(0, "")
+	if withoutToken.Load() != 1 {
+		t.Errorf("the stored token was not accepted")
+	}
+}
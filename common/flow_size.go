@@ -0,0 +1,91 @@
+package common
+
+import (
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FlowSizeDistribution is a discrete distribution of flow sizes in bytes.
+type FlowSizeDistribution struct {
+	Sizes []uint64
+	// relative weights of the sizes, not necessarily summing up to 1
+	Weights []float64
+}
+
+// ParseFlowSizeDistribution parses comma separated sizes with optional weights, e.g. 10KiB:0.7,100KiB:0.2,1MiB:0.1.
+// Sizes without weight have the weight 1, a single size is used for all flows.
+// An empty string returns nil.
+func ParseFlowSizeDistribution(s string) (*FlowSizeDistribution, error) {
+	if s == "" {
+		return nil, nil
+	}
+	d := &FlowSizeDistribution{}
+	for _, option := range strings.Split(s, ",") {
+		sizeValue, weightValue, hasWeight := strings.Cut(strings.TrimSpace(option), ":")
+		size, err := ParseByteCountWithUnit(sizeValue)
+		if err != nil {
+			return nil, fmt.Errorf("invalid flow size %s: %w", sizeValue, err)
+		}
+		if size == 0 {
+			return nil, fmt.Errorf("invalid flow size: %s", sizeValue)
+		}
+		weight := 1.0
+		if hasWeight {
+			weight, err = strconv.ParseFloat(weightValue, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid weight of flow size %s: %w", sizeValue, err)
+			}
+			if weight <= 0 || math.IsInf(weight, 0) {
+				return nil, fmt.Errorf("invalid weight of flow size %s: %s", sizeValue, weightValue)
+			}
+		}
+		d.Sizes = append(d.Sizes, size)
+		d.Weights = append(d.Weights, weight)
+	}
+	return d, nil
+}
+
+// Sample returns a random size according to the weights.
+func (d *FlowSizeDistribution) Sample(r *rand.Rand) uint64 {
+	var sum float64
+	for _, weight := range d.Weights {
+		sum += weight
+	}
+	x := r.Float64() * sum
+	for i, weight := range d.Weights {
+		if x < weight {
+			return d.Sizes[i]
+		}
+		x -= weight
+	}
+	return d.Sizes[len(d.Sizes)-1]
+}
+
+func (d *FlowSizeDistribution) String() string {
+	options := make([]string, len(d.Sizes))
+	for i, size := range d.Sizes {
+		options[i] = fmt.Sprintf("%s:%g", humanize.IBytes(size), d.Weights[i])
+	}
+	return strings.Join(options, ",")
+}
+
+// Percentile returns the p-th percentile (0 to 100) of the values using the nearest-rank method,
+// 0 if there are no values.
+func Percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,42 @@
+package common
+
+import (
+	"math/rand"
+	"testing"
+)
+
+func TestParseFlowSizeDistribution(t *testing.T) {
+	d, err := ParseFlowSizeDistribution("10KiB:3, 1MB")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Sizes) != 2 || d.Sizes[0] != 10*1024 || d.Sizes[1] != 1e6 || d.Weights[0] != 3 || d.Weights[1] != 1 {
+		t.Fatalf("got %+v", d)
+	}
+	for _, s := range []string{"0", "10KiB:0", "10KiB:x", "10KiB,"} {
+		if _, err := ParseFlowSizeDistribution(s); err == nil {
+			t.Errorf("parsed invalid distribution %q", s)
+		}
+	}
+
+	counts := map[uint64]int{}
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 4000; i++ {
+		counts[d.Sample(r)]++
+	}
+	if counts[10*1024] < 2800 || counts[10*1024] > 3200 {
+		t.Errorf("got sizes %v, expected 3/4 of 10 KiB", counts)
+	}
+}
+
+func TestPercentile(t *testing.T) {
+	values := []float64{5, 1, 4, 2, 3}
+	for p, expected := range map[float64]float64{0: 1, 20: 1, 50: 3, 95: 5, 100: 5} {
+		if got := Percentile(values, p); got != expected {
+			t.Errorf("percentile %g: got %g, expected %g", p, got, expected)
+		}
+	}
+	if values[0] != 5 {
+		t.Errorf("values were sorted in place")
+	}
+}
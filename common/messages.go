@@ -1,3 +1,72 @@
 package common
 
-const QPerfStartSendingRequest = "qperf start sending"
+import "time"
+
+// ReceiveReportInterval is the interval in which the server reports received bytes in upload mode.
+const ReceiveReportInterval = 100 * time.Millisecond
+
+const (
+	DirectionDownload      = "download"
+	DirectionUpload        = "upload"
+	DirectionBidirectional = "bidirectional"
+)
+
+// DefaultBlockSize is the default size of a single write on a data stream.
+const DefaultBlockSize = 64 * 1024
+
+// MaxBlockSize is the maximum block size accepted by the server.
+const MaxBlockSize = 1024 * 1024
+
+// TestParameters are sent by the client in the ControlMessageHello.
+type TestParameters struct {
+	// DirectionDownload, DirectionUpload or DirectionBidirectional
+	Direction string `json:"direction"`
+	// the planned duration of the test, measured from the first byte
+	Duration time.Duration `json:"duration"`
+	// bytes sent per stream, 0 for unlimited
+	ByteLimit uint64 `json:"byte_limit,omitempty"`
+	// size of a single write on a data stream
+	BlockSize uint64 `json:"block_size"`
+	// requested congestion control of the server, empty for the server default
+	CongestionControl string `json:"congestion_control,omitempty"`
+	// requested sending rate of the server in bytes per second, e.g. the receive bandwidth of the client, 0 for no target rate
+	TargetRate uint64 `json:"target_rate,omitempty"`
+	// number of data streams per direction
+	Streams uint64 `json:"streams"`
+}
+
+// ServerCapabilities are sent by the server in the ControlMessageHelloAck.
+type ServerCapabilities struct {
+	// ControlProtocolVersion of the server
+	Version uint8 `json:"version"`
+	// whether the server accepted the TestParameters
+	Accepted bool `json:"accepted"`
+	// the reason if the TestParameters are not accepted
+	Error string `json:"error,omitempty"`
+	// supported directions
+	Directions []string `json:"directions"`
+	// supported congestion controls
+	CongestionControls []string `json:"congestion_controls"`
+	// the congestion control used by the server for this connection
+	CongestionControl string `json:"congestion_control"`
+	// maximum number of data streams per connection
+	MaxStreams uint64 `json:"max_streams"`
+	// maximum block size
+	MaxBlockSize uint64 `json:"max_block_size"`
+}
+
+// StreamRequest is sent by the client at the beginning of every data stream.
+type StreamRequest struct {
+	// DirectionDownload or DirectionUpload
+	Direction string `json:"direction"`
+}
+
+// ReceiveReport is sent by the server in upload mode.
+type ReceiveReport struct {
+	// bytes received since the last report
+	Bytes uint64 `json:"bytes"`
+	// packets received since the last report
+	Packets uint64 `json:"packets"`
+	// time since the last report
+	Delta time.Duration `json:"delta"`
+}
@@ -3,22 +3,24 @@ package common
 import (
 	"context"
 	"crypto/tls"
+	"sync"
 )
 
+// SingleSessionCache keeps the first session ticket put into it.
 type SingleSessionCache struct {
-	emptyContext       context.Context
-	emptyContextCancel context.CancelFunc
-	sessionKey         *string
-	session            *tls.ClientSessionState
+	putOnce    sync.Once
+	received   chan struct{}
+	sessionKey string
+	session    *tls.ClientSessionState
 }
 
-// var _ qtls.ClientSessionCache = (*SingleSessionCache)(nil)
+var _ tls.ClientSessionCache = (*SingleSessionCache)(nil)
 
 func (s *SingleSessionCache) Get(sessionKey string) (session *tls.ClientSessionState, ok bool) {
 	select {
-	case <-s.emptyContext.Done():
-		if sessionKey == *s.sessionKey {
-			return session, true
+	case <-s.received:
+		if sessionKey == s.sessionKey {
+			return s.session, true
 		}
 	default: // do not wait
 	}
@@ -26,28 +28,33 @@ func (s *SingleSessionCache) Get(sessionKey string) (session *tls.ClientSessionS
 }
 
 func (s *SingleSessionCache) Put(sessionKey string, cs *tls.ClientSessionState) {
-	select {
-	case <-s.emptyContext.Done():
-		return // already set
-	default:
-		// TODO make thread safe
-		s.sessionKey = &sessionKey
-		s.session = cs
-		s.emptyContextCancel()
+	if cs == nil {
+		return
 	}
+	s.putOnce.Do(func() {
+		s.sessionKey = sessionKey
+		s.session = cs
+		close(s.received)
+	})
 }
 
-func (s *SingleSessionCache) Await() (string, *tls.ClientSessionState) {
-	<-s.emptyContext.Done()
-	return *s.sessionKey, s.session
+// Await waits for the session ticket until ctx is done.
+func (s *SingleSessionCache) Await(ctx context.Context) (string, *tls.ClientSessionState, error) {
+	select {
+	case <-s.received:
+	case <-ctx.Done():
+		// it may have been received in the meantime
+		select {
+		case <-s.received:
+		default:
+			return "", nil, ctx.Err()
+		}
+	}
+	return s.sessionKey, s.session, nil
 }
 
 func NewSingleSessionCache() *SingleSessionCache {
-	emptyContext, emptyContextCancel := context.WithCancel(context.Background())
 	return &SingleSessionCache{
-		emptyContext,
-		emptyContextCancel,
-		nil,
-		nil,
+		received: make(chan struct{}),
 	}
 }
@@ -3,13 +3,15 @@ package common
 import (
 	"context"
 	"github.com/apernet/quic-go"
+	"sync"
 )
 
+// SingleTokenStore keeps the first token put into it.
 type SingleTokenStore struct {
-	emptyContext       context.Context
-	emptyContextCancel context.CancelFunc
-	key                *string
-	token              *quic.ClientToken
+	putOnce  sync.Once
+	received chan struct{}
+	key      string
+	token    *quic.ClientToken
 }
 
 var _ quic.TokenStore = (*SingleTokenStore)(nil)
@@ -17,8 +19,8 @@ var _ quic.TokenStore = (*SingleTokenStore)(nil)
 // Pop does not remove the token
 func (s *SingleTokenStore) Pop(key string) (token *quic.ClientToken) {
 	select {
-	case <-s.emptyContext.Done():
-		if key == *s.key {
+	case <-s.received:
+		if key == s.key {
 			return s.token
 		}
 	default: // do not wait
@@ -27,27 +29,30 @@ func (s *SingleTokenStore) Pop(key string) (token *quic.ClientToken) {
 }
 
 func (s *SingleTokenStore) Put(key string, token *quic.ClientToken) {
-	select {
-	case <-s.emptyContext.Done():
-		return // already set
-	default:
-		// TODO make thread safe
-		s.key = &key
+	s.putOnce.Do(func() {
+		s.key = key
 		s.token = token
-		s.emptyContextCancel()
-	}
+		close(s.received)
+	})
 }
 
-func (s *SingleTokenStore) Await() (string, *quic.ClientToken) {
-	<-s.emptyContext.Done()
-	return *s.key, s.token
+// Await waits for the token until ctx is done.
+func (s *SingleTokenStore) Await(ctx context.Context) (string, *quic.ClientToken, error) {
+	select {
+	case <-s.received:
+	case <-ctx.Done():
+		// it may have been received in the meantime
+		select {
+		case <-s.received:
+		default:
+			return "", nil, ctx.Err()
+		}
+	}
+	return s.key, s.token, nil
 }
+
 func NewSingleTokenStore() *SingleTokenStore {
-	emptyContext, emptyContextCancel := context.WithCancel(context.Background())
 	return &SingleTokenStore{
-		emptyContext,
-		emptyContextCancel,
-		nil,
-		nil,
+		received: make(chan struct{}),
 	}
 }
@@ -15,6 +15,27 @@ type State struct {
 	lastReportTime            time.Time
 	lastReportReceivedBytes   uint64
 	lastReportReceivedPackets uint64
+	packets                   PacketCounters
+	lastReportPackets         PacketCounters
+}
+
+// PacketCounters are the QUIC packets of a connection, sizes include the QUIC headers.
+type PacketCounters struct {
+	SentPackets     uint64
+	SentBytes       uint64
+	ReceivedPackets uint64
+	ReceivedBytes   uint64
+	LostPackets     uint64
+}
+
+func (p PacketCounters) sub(o PacketCounters) PacketCounters {
+	return PacketCounters{
+		SentPackets:     p.SentPackets - o.SentPackets,
+		SentBytes:       p.SentBytes - o.SentBytes,
+		ReceivedPackets: p.ReceivedPackets - o.ReceivedPackets,
+		ReceivedBytes:   p.ReceivedBytes - o.ReceivedBytes,
+		LostPackets:     p.LostPackets - o.LostPackets,
+	}
 }
 
 func (s *State) AddReceivedBytes(receivedBytes uint64) {
@@ -32,6 +53,41 @@ func (s *State) AddReceivedPackets(receivedPackets uint64) {
 	s.mutex.Unlock()
 }
 
+func (s *State) AddSentPacket(size uint64) {
+	s.mutex.Lock()
+	s.packets.SentPackets++
+	s.packets.SentBytes += size
+	s.mutex.Unlock()
+}
+
+func (s *State) AddReceivedPacket(size uint64) {
+	s.mutex.Lock()
+	s.packets.ReceivedPackets++
+	s.packets.ReceivedBytes += size
+	s.mutex.Unlock()
+}
+
+func (s *State) AddLostPacket() {
+	s.mutex.Lock()
+	s.packets.LostPackets++
+	s.mutex.Unlock()
+}
+
+// GetAndResetPacketReport returns the packets since the previous call.
+func (s *State) GetAndResetPacketReport() PacketCounters {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	report := s.packets.sub(s.lastReportPackets)
+	s.lastReportPackets = s.packets
+	return report
+}
+
+func (s *State) Packets() PacketCounters {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.packets
+}
+
 func (s *State) GetAndResetReport() (receivedBytes uint64, receivedPackets uint64, delta time.Duration) {
 	now := time.Now()
 	s.mutex.Lock()
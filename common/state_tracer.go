@@ -4,27 +4,38 @@ import (
 	"github.com/apernet/quic-go/logging"
 )
 
-type StateTracer struct {
-	logging.Tracer
-	State *State
+// NewStateConnectionTracer counts the packets of a connection in state, see PacketCounters.
+func NewStateConnectionTracer(state *State) *logging.ConnectionTracer {
+	return &logging.ConnectionTracer{
+		SentLongHeaderPacket: func(_ *logging.ExtendedHeader, size logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, _ []logging.Frame) {
+			state.AddSentPacket(uint64(size))
+		},
+		SentShortHeaderPacket: func(_ *logging.ShortHeader, size logging.ByteCount, _ logging.ECN, _ *logging.AckFrame, _ []logging.Frame) {
+			state.AddSentPacket(uint64(size))
+		},
+		ReceivedLongHeaderPacket: func(_ *logging.ExtendedHeader, size logging.ByteCount, _ logging.ECN, _ []logging.Frame) {
+			state.AddReceivedPacket(uint64(size))
+		},
+		ReceivedShortHeaderPacket: func(_ *logging.ShortHeader, size logging.ByteCount, _ logging.ECN, _ []logging.Frame) {
+			state.AddReceivedPacket(uint64(size))
+		},
+		LostPacket: func(logging.EncryptionLevel, logging.PacketNumber, logging.PacketLossReason) {
+			state.AddLostPacket()
+		},
+	}
 }
 
-// func (a StateTracer) TracerForConnection(ctx context.Context, p logging.Perspective, odcid logging.ConnectionID) logging.ConnectionTracer {
-// 	return StateConnectionTracer{
-// 		State: a.State,
-// 	}
-// }
-
-type StateConnectionTracer struct {
-	logging.ConnectionTracer
-	State *State
-}
-
-func (n StateConnectionTracer) ReceivedLongHeaderPacket(*logging.ExtendedHeader, logging.ByteCount, []logging.Frame) {
-	n.State.AddReceivedPackets(1)
-}
-
-func (n StateConnectionTracer) ReceivedShortHeaderPacket(*logging.ShortHeader, logging.ByteCount, []logging.Frame) {
-	n.State.AddReceivedPackets(1)
-
+// NewMultiplexedConnectionTracer combines the tracers, nil tracers are skipped,
+// e.g. the one of qlog.DefaultTracer if QLOGDIR is not set.
+func NewMultiplexedConnectionTracer(tracers ...*logging.ConnectionTracer) *logging.ConnectionTracer {
+	nonNil := make([]*logging.ConnectionTracer, 0, len(tracers))
+	for _, tracer := range tracers {
+		if tracer != nil {
+			nonNil = append(nonNil, tracer)
+		}
+	}
+	if len(nonNil) == 1 {
+		return nonNil[0]
+	}
+	return logging.NewMultiplexedConnectionTracer(nonNil...)
 }
@@ -0,0 +1,29 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/apernet/quic-go/logging"
+)
+
+func TestStateConnectionTracer(t *testing.T) {
+	state := &State{}
+	// qlog.DefaultTracer returns nil if QLOGDIR is not set
+	tracer := NewMultiplexedConnectionTracer(NewStateConnectionTracer(state), nil)
+	tracer.SentShortHeaderPacket(&logging.ShortHeader{}, 1200, logging.ECNUnsupported, nil, nil)
+	tracer.SentLongHeaderPacket(&logging.ExtendedHeader{}, 1252, logging.ECNUnsupported, nil, nil)
+	tracer.ReceivedShortHeaderPacket(&logging.ShortHeader{}, 50, logging.ECNUnsupported, nil)
+	tracer.LostPacket(logging.Encryption1RTT, 1, logging.PacketLossReorderingThreshold)
+
+	expected := PacketCounters{SentPackets: 2, SentBytes: 2452, ReceivedPackets: 1, ReceivedBytes: 50, LostPackets: 1}
+	if report := state.GetAndResetPacketReport(); report != expected {
+		t.Errorf("got %+v, expected %+v", report, expected)
+	}
+	tracer.ReceivedShortHeaderPacket(&logging.ShortHeader{}, 50, logging.ECNUnsupported, nil)
+	if report := state.GetAndResetPacketReport(); report != (PacketCounters{ReceivedPackets: 1, ReceivedBytes: 50}) {
+		t.Errorf("got %+v after the first report", report)
+	}
+	if total := state.Packets(); total.ReceivedPackets != 2 {
+		t.Errorf("got %d received packets in total", total.ReceivedPackets)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"math/rand"
 	"net"
 	"qperf-go/internal/congestion/common"
+	"sync"
 	"time"
 
 	"github.com/apernet/quic-go/congestion"
@@ -236,6 +237,10 @@ type bbrSender struct {
 	maxDatagramSize congestion.ByteCount
 	// Recorded on packet sent. equivalent |unacked_packets_->bytes_in_flight()|
 	bytesInFlight congestion.ByteCount
+
+	// Snapshot for State, updated on every congestion event.
+	stateMutex sync.Mutex
+	state      State
 }
 
 var _ congestion.CongestionControl = &bbrSender{}
@@ -295,6 +300,8 @@ func newBbrSender(
 
 	b.enterStartupMode(b.clock.Now())
 	b.setHighCwndGain(derivedHighCWNDGain)
+	// the RTT stats are not set yet, so the pacing rate is unknown until the first congestion event
+	b.state = State{Mode: b.mode.String(), CongestionWindow: b.congestionWindow}
 
 	return b
 }
@@ -498,6 +505,8 @@ func (b *bbrSender) OnCongestionEventEx(priorInFlight congestion.ByteCount, even
 		b.numLossEventsInRound = 0
 		b.bytesLostInRound = 0
 	}
+
+	b.updateState()
 }
 
 func (b *bbrSender) PacingRate() Bandwidth {
@@ -0,0 +1,73 @@
+package bbr
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dustin/go-humanize"
+
+	"github.com/apernet/quic-go/congestion"
+)
+
+func (m bbrMode) String() string {
+	switch m {
+	case bbrModeStartup:
+		return "startup"
+	case bbrModeDrain:
+		return "drain"
+	case bbrModeProbeBw:
+		return "probe_bw"
+	case bbrModeProbeRtt:
+		return "probe_rtt"
+	default:
+		return "unknown"
+	}
+}
+
+// State is a snapshot of the internal state of the BBR sender.
+type State struct {
+	// startup, drain, probe_bw or probe_rtt
+	Mode string
+	// current pacing rate
+	PacingRate Bandwidth
+	// maximum bandwidth of the recent round trips
+	BandwidthEstimate Bandwidth
+	// minimum RTT estimate, 0 if there is no sample yet
+	MinRtt           time.Duration
+	CongestionWindow congestion.ByteCount
+}
+
+func (s State) String() string {
+	return fmt.Sprintf("mode %s, pacing rate %s, bandwidth estimate %s, min rtt %s, cwnd %s",
+		s.Mode,
+		humanize.SIWithDigits(float64(s.PacingRate), 2, "bit/s"),
+		humanize.SIWithDigits(float64(s.BandwidthEstimate), 2, "bit/s"),
+		s.MinRtt,
+		humanize.SI(float64(s.CongestionWindow), "B"))
+}
+
+// StateProvider exposes the internal state of a BBR sender.
+// State is safe to call from other goroutines than the one driving the connection.
+type StateProvider interface {
+	State() State
+}
+
+func (b *bbrSender) State() State {
+	b.stateMutex.Lock()
+	defer b.stateMutex.Unlock()
+	return b.state
+}
+
+// updateState takes a snapshot of the internal state, see State.
+func (b *bbrSender) updateState() {
+	state := State{
+		Mode:              b.mode.String(),
+		PacingRate:        b.PacingRate(),
+		BandwidthEstimate: b.bandwidthEstimate(),
+		MinRtt:            b.minRtt,
+		CongestionWindow:  b.GetCongestionWindow(),
+	}
+	b.stateMutex.Lock()
+	b.state = state
+	b.stateMutex.Unlock()
+}
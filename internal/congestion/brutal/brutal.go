@@ -11,10 +11,11 @@ import (
 )
 
 const (
-	pktInfoSlotCount           = 5 // slot index is based on seconds, so this is basically how many seconds we sample
-	minSampleCount             = 50
-	minAckRate                 = 0.8
-	congestionWindowMultiplier = 2
+	pktInfoSlotCount = 5 // slot index is based on seconds, so this is basically how many seconds we sample
+	minSampleCount   = 50
+
+	DefaultMinAckRate                 = 0.8
+	DefaultCongestionWindowMultiplier = 2
 
 	debugEnv           = "HYSTERIA_BRUTAL_DEBUG"
 	debugPrintInterval = 2
@@ -22,11 +23,23 @@ const (
 
 var _ congestion.CongestionControl = &BrutalSender{}
 
+// Config of the BrutalSender, zero values are replaced by the defaults.
+type Config struct {
+	// target sending rate in bytes per second
+	Bps uint64
+	// lower bound of the ack rate used to compensate losses
+	MinAckRate float64
+	// congestion window in multiples of the bandwidth-delay product
+	CongestionWindowMultiplier float64
+}
+
 type BrutalSender struct {
-	rttStats        congestion.RTTStatsProvider
-	bps             congestion.ByteCount
-	maxDatagramSize congestion.ByteCount
-	pacer           *common.Pacer
+	rttStats                   congestion.RTTStatsProvider
+	bps                        congestion.ByteCount
+	minAckRate                 float64
+	congestionWindowMultiplier float64
+	maxDatagramSize            congestion.ByteCount
+	pacer                      *common.Pacer
 
 	pktInfoSlots [pktInfoSlotCount]pktInfo
 	ackRate      float64
@@ -41,13 +54,21 @@ type pktInfo struct {
 	LossCount uint64
 }
 
-func NewBrutalSender(bps uint64) *BrutalSender {
+func NewBrutalSender(config *Config) *BrutalSender {
 	debug, _ := strconv.ParseBool(os.Getenv(debugEnv))
 	bs := &BrutalSender{
-		bps:             congestion.ByteCount(bps),
-		maxDatagramSize: congestion.InitialPacketSizeIPv4,
-		ackRate:         1,
-		debug:           debug,
+		bps:                        congestion.ByteCount(config.Bps),
+		minAckRate:                 config.MinAckRate,
+		congestionWindowMultiplier: config.CongestionWindowMultiplier,
+		maxDatagramSize:            congestion.InitialPacketSizeIPv4,
+		ackRate:                    1,
+		debug:                      debug,
+	}
+	if bs.minAckRate == 0 {
+		bs.minAckRate = DefaultMinAckRate
+	}
+	if bs.congestionWindowMultiplier == 0 {
+		bs.congestionWindowMultiplier = DefaultCongestionWindowMultiplier
 	}
 	bs.pacer = common.NewPacer(func() congestion.ByteCount {
 		return congestion.ByteCount(float64(bs.bps) / bs.ackRate)
@@ -76,7 +97,7 @@ func (b *BrutalSender) GetCongestionWindow() congestion.ByteCount {
 	if rtt <= 0 {
 		return 10240
 	}
-	return congestion.ByteCount(float64(b.bps) * rtt.Seconds() * congestionWindowMultiplier / b.ackRate)
+	return congestion.ByteCount(float64(b.bps) * rtt.Seconds() * b.congestionWindowMultiplier / b.ackRate)
 }
 
 func (b *BrutalSender) OnPacketSent(sentTime time.Time, bytesInFlight congestion.ByteCount,
@@ -140,12 +161,12 @@ func (b *BrutalSender) updateAckRate(currentTimestamp int64) {
 		return
 	}
 	rate := float64(ackCount) / float64(ackCount+lossCount)
-	if rate < minAckRate {
-		b.ackRate = minAckRate
+	if rate < b.minAckRate {
+		b.ackRate = b.minAckRate
 		if b.canPrintAckRate(currentTimestamp) {
 			b.lastAckPrintTimestamp = currentTimestamp
 			b.debugPrint("ACK rate too low: %.2f, clamped to %.2f (total=%d, ack=%d, loss=%d, rtt=%d)",
-				rate, minAckRate, ackCount+lossCount, ackCount, lossCount, b.rttStats.SmoothedRTT().Milliseconds())
+				rate, b.minAckRate, ackCount+lossCount, ackCount, lossCount, b.rttStats.SmoothedRTT().Milliseconds())
 		}
 		return
 	}
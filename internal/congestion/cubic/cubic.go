@@ -2,8 +2,9 @@ package cubic
 
 import (
 	"math"
-	"qperf-go/internal/protocol"
 	"time"
+
+	"github.com/apernet/quic-go/congestion"
 )
 
 // This cubic implementation is based on the one found in Chromiums's QUIC
@@ -16,11 +17,11 @@ import (
 // 1024*1024^3 (first 1024 is from 0.100^3)
 // where 0.100 is 100 ms which is the scaling round trip time.
 const (
-	cubeScale                                    = 40
-	cubeCongestionWindowScale                    = 410
-	cubeFactor                protocol.ByteCount = 1 << cubeScale / cubeCongestionWindowScale / maxDatagramSize
+	cubeScale                                      = 40
+	cubeCongestionWindowScale                      = 410
+	cubeFactor                congestion.ByteCount = 1 << cubeScale / cubeCongestionWindowScale / maxDatagramSize
 	// TODO: when re-enabling cubic, make sure to use the actual packet size here
-	maxDatagramSize = protocol.ByteCount(protocol.InitialPacketSizeIPv4)
+	maxDatagramSize = congestion.ByteCount(congestion.InitialPacketSizeIPv4)
 )
 
 const defaultNumConnections = 1
@@ -46,22 +47,22 @@ type Cubic struct {
 	// Max congestion window used just before last loss event.
 	// Note: to improve fairness to other streams an additional back off is
 	// applied to this value if the new value is below our latest value.
-	lastMaxCongestionWindow protocol.ByteCount
+	lastMaxCongestionWindow congestion.ByteCount
 
 	// Number of acked bytes since the cycle started (epoch).
-	ackedBytesCount protocol.ByteCount
+	ackedBytesCount congestion.ByteCount
 
 	// TCP Reno equivalent congestion window in packets.
-	estimatedTCPcongestionWindow protocol.ByteCount
+	estimatedTCPcongestionWindow congestion.ByteCount
 
 	// Origin point of cubic function.
-	originPointCongestionWindow protocol.ByteCount
+	originPointCongestionWindow congestion.ByteCount
 
 	// Time to origin point of cubic function in 2^10 fractions of a second.
 	timeToOriginPoint uint32
 
 	// Last congestion window in packets computed by cubic function.
-	lastTargetCongestionWindow protocol.ByteCount
+	lastTargetCongestionWindow congestion.ByteCount
 }
 
 // NewCubic returns a new Cubic instance
@@ -126,16 +127,16 @@ func (c *Cubic) OnApplicationLimited() {
 // CongestionWindowAfterPacketLoss computes a new congestion window to use after
 // a loss event. Returns the new congestion window in packets. The new
 // congestion window is a multiplicative decrease of our current window.
-func (c *Cubic) CongestionWindowAfterPacketLoss(currentCongestionWindow protocol.ByteCount) protocol.ByteCount {
+func (c *Cubic) CongestionWindowAfterPacketLoss(currentCongestionWindow congestion.ByteCount) congestion.ByteCount {
 	if currentCongestionWindow+maxDatagramSize < c.lastMaxCongestionWindow {
 		// We never reached the old max, so assume we are competing with another
 		// flow. Use our extra back off factor to allow the other flow to go up.
-		c.lastMaxCongestionWindow = protocol.ByteCount(c.betaLastMax() * float32(currentCongestionWindow))
+		c.lastMaxCongestionWindow = congestion.ByteCount(c.betaLastMax() * float32(currentCongestionWindow))
 	} else {
 		c.lastMaxCongestionWindow = currentCongestionWindow
 	}
 	c.epoch = time.Time{} // Reset time.
-	return protocol.ByteCount(float32(currentCongestionWindow) * c.beta())
+	return congestion.ByteCount(float32(currentCongestionWindow) * c.beta())
 }
 
 // CongestionWindowAfterAck computes a new congestion window to use after a received ACK.
@@ -143,11 +144,11 @@ func (c *Cubic) CongestionWindowAfterPacketLoss(currentCongestionWindow protocol
 // follows a cubic function that depends on the time passed since last
 // packet loss.
 func (c *Cubic) CongestionWindowAfterAck(
-	ackedBytes protocol.ByteCount,
-	currentCongestionWindow protocol.ByteCount,
+	ackedBytes congestion.ByteCount,
+	currentCongestionWindow congestion.ByteCount,
 	delayMin time.Duration,
 	eventTime time.Time,
-) protocol.ByteCount {
+) congestion.ByteCount {
 	c.ackedBytesCount += ackedBytes
 
 	if c.epoch.IsZero() {
@@ -177,8 +178,8 @@ func (c *Cubic) CongestionWindowAfterAck(
 		offset = -offset
 	}
 
-	deltaCongestionWindow := protocol.ByteCount(cubeCongestionWindowScale*offset*offset*offset) * maxDatagramSize >> cubeScale
-	var targetCongestionWindow protocol.ByteCount
+	deltaCongestionWindow := congestion.ByteCount(cubeCongestionWindowScale*offset*offset*offset) * maxDatagramSize >> cubeScale
+	var targetCongestionWindow congestion.ByteCount
 	if elapsedTime > int64(c.timeToOriginPoint) {
 		targetCongestionWindow = c.originPointCongestionWindow + deltaCongestionWindow
 	} else {
@@ -192,7 +193,7 @@ func (c *Cubic) CongestionWindowAfterAck(
 	// congestion windows (less than 25), the formula below will
 	// increase slightly slower than linearly per estimated tcp window
 	// of bytes.
-	c.estimatedTCPcongestionWindow += protocol.ByteCount(float32(c.ackedBytesCount) * c.alpha() * float32(maxDatagramSize) / float32(c.estimatedTCPcongestionWindow))
+	c.estimatedTCPcongestionWindow += congestion.ByteCount(float32(c.ackedBytesCount) * c.alpha() * float32(maxDatagramSize) / float32(c.estimatedTCPcongestionWindow))
 	c.ackedBytesCount = 0
 
 	// We have a new cubic congestion window.
@@ -2,121 +2,112 @@ package cubic
 
 import (
 	"fmt"
+	"qperf-go/internal/congestion/common"
 	"time"
 
-	"github.com/quic-go/quic-go/logging"
-	"qperf-go/internal/protocol"
-	"qperf-go/internal/utils"
+	"github.com/apernet/quic-go/congestion"
 )
 
 const (
 	// maxDatagramSize is the default maximum packet size used in the Linux TCP implementation.
 	// Used in QUIC for congestion window computations in bytes.
-	initialMaxDatagramSize     = protocol.ByteCount(protocol.InitialPacketSizeIPv4)
+	initialMaxDatagramSize     = congestion.ByteCount(congestion.InitialPacketSizeIPv4)
 	maxBurstPackets            = 3
 	renoBeta                   = 0.7 // Reno backoff factor.
 	minCongestionWindowPackets = 2
 	initialCongestionWindow    = 32
+
+	invalidPacketNumber = congestion.PacketNumber(-1)
+	maxByteCount        = congestion.ByteCount(1<<62 - 1)
+	// used for pacing until the first RTT sample
+	defaultInitialRTT = 100 * time.Millisecond
 )
 
 type cubicSender struct {
 	hybridSlowStart HybridSlowStart
-	rttStats        *utils.RTTStats
+	rttStats        congestion.RTTStatsProvider
 	cubic           *Cubic
-	pacer           *pacer
+	pacer           *common.Pacer
 	clock           Clock
 
 	reno bool
 
 	// Track the largest packet that has been sent.
-	largestSentPacketNumber protocol.PacketNumber
+	largestSentPacketNumber congestion.PacketNumber
 
 	// Track the largest packet that has been acked.
-	largestAckedPacketNumber protocol.PacketNumber
+	largestAckedPacketNumber congestion.PacketNumber
 
 	// Track the largest packet number outstanding when a CWND cutback occurs.
-	largestSentAtLastCutback protocol.PacketNumber
+	largestSentAtLastCutback congestion.PacketNumber
 
 	// Whether the last loss event caused us to exit slowstart.
 	// Used for stats collection of slowstartPacketsLost
 	lastCutbackExitedSlowstart bool
 
 	// Congestion window in bytes.
-	congestionWindow protocol.ByteCount
+	congestionWindow congestion.ByteCount
 
 	// Slow start congestion window in bytes, aka ssthresh.
-	slowStartThreshold protocol.ByteCount
+	slowStartThreshold congestion.ByteCount
 
 	// ACK counter for the Reno implementation.
 	numAckedPackets uint64
 
-	initialCongestionWindow    protocol.ByteCount
-	initialMaxCongestionWindow protocol.ByteCount
-
-	maxDatagramSize protocol.ByteCount
+	initialCongestionWindow    congestion.ByteCount
+	initialMaxCongestionWindow congestion.ByteCount
 
-	lastState logging.CongestionState
-	tracer    *logging.ConnectionTracer
+	maxDatagramSize congestion.ByteCount
 }
 
-var (
-	_ SendAlgorithm               = &cubicSender{}
-	_ SendAlgorithmWithDebugInfos = &cubicSender{}
-)
+var _ congestion.CongestionControl = &cubicSender{}
 
-// NewCubicSender makes a new cubic sender
+// NewCubicSender makes a new cubic sender, or a Reno sender if reno is set.
+// The RTT stats are set by quic-go with SetRTTStatsProvider.
 func NewCubicSender(
 	clock Clock,
-	rttStats *utils.RTTStats,
-	initialMaxDatagramSize protocol.ByteCount,
+	initialMaxDatagramSize congestion.ByteCount,
 	reno bool,
-	tracer *logging.ConnectionTracer,
 ) *cubicSender {
 	return newCubicSender(
 		clock,
-		rttStats,
 		reno,
 		initialMaxDatagramSize,
 		initialCongestionWindow*initialMaxDatagramSize,
-		protocol.MaxCongestionWindowPackets*initialMaxDatagramSize,
-		tracer,
+		congestion.MaxCongestionWindowPackets*initialMaxDatagramSize,
 	)
 }
 
 func newCubicSender(
 	clock Clock,
-	rttStats *utils.RTTStats,
 	reno bool,
 	initialMaxDatagramSize,
 	initialCongestionWindow,
-	initialMaxCongestionWindow protocol.ByteCount,
-	tracer *logging.ConnectionTracer,
+	initialMaxCongestionWindow congestion.ByteCount,
 ) *cubicSender {
 	c := &cubicSender{
-		rttStats:                   rttStats,
-		largestSentPacketNumber:    protocol.InvalidPacketNumber,
-		largestAckedPacketNumber:   protocol.InvalidPacketNumber,
-		largestSentAtLastCutback:   protocol.InvalidPacketNumber,
+		largestSentPacketNumber:    invalidPacketNumber,
+		largestAckedPacketNumber:   invalidPacketNumber,
+		largestSentAtLastCutback:   invalidPacketNumber,
 		initialCongestionWindow:    initialCongestionWindow,
 		initialMaxCongestionWindow: initialMaxCongestionWindow,
 		congestionWindow:           initialCongestionWindow,
-		slowStartThreshold:         protocol.MaxByteCount,
+		slowStartThreshold:         maxByteCount,
 		cubic:                      NewCubic(clock),
 		clock:                      clock,
 		reno:                       reno,
-		tracer:                     tracer,
 		maxDatagramSize:            initialMaxDatagramSize,
 	}
-	c.pacer = newPacer(c.BandwidthEstimate)
-	if c.tracer != nil && c.tracer.UpdatedCongestionState != nil {
-		c.lastState = logging.CongestionStateSlowStart
-		c.tracer.UpdatedCongestionState(logging.CongestionStateSlowStart)
-	}
+	c.pacer = common.NewPacer(c.bandwidthForPacer)
 	return c
 }
 
+func (c *cubicSender) SetRTTStatsProvider(provider congestion.RTTStatsProvider) {
+	c.rttStats = provider
+}
+
 // TimeUntilSend returns when the next packet should be sent.
-func (c *cubicSender) TimeUntilSend(_ protocol.ByteCount) time.Time {
+func (c *cubicSender) TimeUntilSend(_ congestion.ByteCount) time.Time {
 	return c.pacer.TimeUntilSend()
 }
 
@@ -124,19 +115,19 @@ func (c *cubicSender) HasPacingBudget(now time.Time) bool {
 	return c.pacer.Budget(now) >= c.maxDatagramSize
 }
 
-func (c *cubicSender) maxCongestionWindow() protocol.ByteCount {
-	return c.maxDatagramSize * protocol.MaxCongestionWindowPackets
+func (c *cubicSender) maxCongestionWindow() congestion.ByteCount {
+	return c.maxDatagramSize * congestion.MaxCongestionWindowPackets
 }
 
-func (c *cubicSender) minCongestionWindow() protocol.ByteCount {
+func (c *cubicSender) minCongestionWindow() congestion.ByteCount {
 	return c.maxDatagramSize * minCongestionWindowPackets
 }
 
 func (c *cubicSender) OnPacketSent(
 	sentTime time.Time,
-	_ protocol.ByteCount,
-	packetNumber protocol.PacketNumber,
-	bytes protocol.ByteCount,
+	_ congestion.ByteCount,
+	packetNumber congestion.PacketNumber,
+	bytes congestion.ByteCount,
 	isRetransmittable bool,
 ) {
 	c.pacer.SentPacket(sentTime, bytes)
@@ -147,19 +138,19 @@ func (c *cubicSender) OnPacketSent(
 	c.hybridSlowStart.OnPacketSent(packetNumber)
 }
 
-func (c *cubicSender) CanSend(bytesInFlight protocol.ByteCount) bool {
+func (c *cubicSender) CanSend(bytesInFlight congestion.ByteCount) bool {
 	return bytesInFlight < c.GetCongestionWindow()
 }
 
 func (c *cubicSender) InRecovery() bool {
-	return c.largestAckedPacketNumber != protocol.InvalidPacketNumber && c.largestAckedPacketNumber <= c.largestSentAtLastCutback
+	return c.largestAckedPacketNumber != invalidPacketNumber && c.largestAckedPacketNumber <= c.largestSentAtLastCutback
 }
 
 func (c *cubicSender) InSlowStart() bool {
 	return c.GetCongestionWindow() < c.slowStartThreshold
 }
 
-func (c *cubicSender) GetCongestionWindow() protocol.ByteCount {
+func (c *cubicSender) GetCongestionWindow() congestion.ByteCount {
 	return c.congestionWindow
 }
 
@@ -168,14 +159,13 @@ func (c *cubicSender) MaybeExitSlowStart() {
 		c.hybridSlowStart.ShouldExitSlowStart(c.rttStats.LatestRTT(), c.rttStats.MinRTT(), c.GetCongestionWindow()/c.maxDatagramSize) {
 		// exit slow start
 		c.slowStartThreshold = c.congestionWindow
-		c.maybeTraceStateChange(logging.CongestionStateCongestionAvoidance)
 	}
 }
 
 func (c *cubicSender) OnPacketAcked(
-	ackedPacketNumber protocol.PacketNumber,
-	ackedBytes protocol.ByteCount,
-	priorInFlight protocol.ByteCount,
+	ackedPacketNumber congestion.PacketNumber,
+	ackedBytes congestion.ByteCount,
+	priorInFlight congestion.ByteCount,
 	eventTime time.Time,
 ) {
 	c.largestAckedPacketNumber = max(ackedPacketNumber, c.largestAckedPacketNumber)
@@ -188,17 +178,16 @@ func (c *cubicSender) OnPacketAcked(
 	}
 }
 
-func (c *cubicSender) OnCongestionEvent(packetNumber protocol.PacketNumber, lostBytes, priorInFlight protocol.ByteCount) {
+func (c *cubicSender) OnCongestionEvent(packetNumber congestion.PacketNumber, lostBytes, priorInFlight congestion.ByteCount) {
 	// TCP NewReno (RFC6582) says that once a loss occurs, any losses in packets
 	// already sent should be treated as a single loss event, since it's expected.
 	if packetNumber <= c.largestSentAtLastCutback {
 		return
 	}
 	c.lastCutbackExitedSlowstart = c.InSlowStart()
-	c.maybeTraceStateChange(logging.CongestionStateRecovery)
 
 	if c.reno {
-		c.congestionWindow = protocol.ByteCount(float64(c.congestionWindow) * renoBeta)
+		c.congestionWindow = congestion.ByteCount(float64(c.congestionWindow) * renoBeta)
 	} else {
 		c.congestionWindow = c.cubic.CongestionWindowAfterPacketLoss(c.congestionWindow)
 	}
@@ -215,16 +204,15 @@ func (c *cubicSender) OnCongestionEvent(packetNumber protocol.PacketNumber, lost
 // Called when we receive an ack. Normal TCP tracks how many packets one ack
 // represents, but quic has a separate ack for each packet.
 func (c *cubicSender) maybeIncreaseCwnd(
-	_ protocol.PacketNumber,
-	ackedBytes protocol.ByteCount,
-	priorInFlight protocol.ByteCount,
+	_ congestion.PacketNumber,
+	ackedBytes congestion.ByteCount,
+	priorInFlight congestion.ByteCount,
 	eventTime time.Time,
 ) {
 	// Do not increase the congestion window unless the sender is close to using
 	// the current window.
 	if !c.isCwndLimited(priorInFlight) {
 		c.cubic.OnApplicationLimited()
-		c.maybeTraceStateChange(logging.CongestionStateApplicationLimited)
 		return
 	}
 	if c.congestionWindow >= c.maxCongestionWindow() {
@@ -233,11 +221,9 @@ func (c *cubicSender) maybeIncreaseCwnd(
 	if c.InSlowStart() {
 		// TCP slow start, exponential growth, increase by one for each ACK.
 		c.congestionWindow += c.maxDatagramSize
-		c.maybeTraceStateChange(logging.CongestionStateSlowStart)
 		return
 	}
 	// Congestion avoidance
-	c.maybeTraceStateChange(logging.CongestionStateCongestionAvoidance)
 	if c.reno {
 		// Classic Reno congestion avoidance.
 		c.numAckedPackets++
@@ -250,7 +236,7 @@ func (c *cubicSender) maybeIncreaseCwnd(
 	}
 }
 
-func (c *cubicSender) isCwndLimited(bytesInFlight protocol.ByteCount) bool {
+func (c *cubicSender) isCwndLimited(bytesInFlight congestion.ByteCount) bool {
 	congestionWindow := c.GetCongestionWindow()
 	if bytesInFlight >= congestionWindow {
 		return true
@@ -260,6 +246,20 @@ func (c *cubicSender) isCwndLimited(bytesInFlight protocol.ByteCount) bool {
 	return slowStartLimited || availableBytes <= maxBurstPackets*c.maxDatagramSize
 }
 
+// OnCongestionEventEx is not needed, quic-go reports acks and losses with OnPacketAcked and OnCongestionEvent.
+func (c *cubicSender) OnCongestionEventEx(priorInFlight congestion.ByteCount, eventTime time.Time, ackedPackets []congestion.AckedPacketInfo, lostPackets []congestion.LostPacketInfo) {
+}
+
+// bandwidthForPacer returns a slightly higher value than the bandwidth estimate in bytes per second,
+// so RTT variations don't result in under-utilization of the congestion window.
+func (c *cubicSender) bandwidthForPacer() congestion.ByteCount {
+	srtt := c.rttStats.SmoothedRTT()
+	if srtt == 0 {
+		srtt = defaultInitialRTT
+	}
+	return congestion.ByteCount(BandwidthFromDelta(c.GetCongestionWindow(), srtt)/BytesPerSecond) * 5 / 4
+}
+
 // BandwidthEstimate returns the current bandwidth estimate
 func (c *cubicSender) BandwidthEstimate() Bandwidth {
 	srtt := c.rttStats.SmoothedRTT()
@@ -272,7 +272,7 @@ func (c *cubicSender) BandwidthEstimate() Bandwidth {
 
 // OnRetransmissionTimeout is called on an retransmission timeout
 func (c *cubicSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
-	c.largestSentAtLastCutback = protocol.InvalidPacketNumber
+	c.largestSentAtLastCutback = invalidPacketNumber
 	if !packetsRetransmitted {
 		return
 	}
@@ -285,9 +285,9 @@ func (c *cubicSender) OnRetransmissionTimeout(packetsRetransmitted bool) {
 // OnConnectionMigration is called when the connection is migrated (?)
 func (c *cubicSender) OnConnectionMigration() {
 	c.hybridSlowStart.Restart()
-	c.largestSentPacketNumber = protocol.InvalidPacketNumber
-	c.largestAckedPacketNumber = protocol.InvalidPacketNumber
-	c.largestSentAtLastCutback = protocol.InvalidPacketNumber
+	c.largestSentPacketNumber = invalidPacketNumber
+	c.largestAckedPacketNumber = invalidPacketNumber
+	c.largestSentAtLastCutback = invalidPacketNumber
 	c.lastCutbackExitedSlowstart = false
 	c.cubic.Reset()
 	c.numAckedPackets = 0
@@ -295,15 +295,7 @@ func (c *cubicSender) OnConnectionMigration() {
 	c.slowStartThreshold = c.initialMaxCongestionWindow
 }
 
-func (c *cubicSender) maybeTraceStateChange(new logging.CongestionState) {
-	if c.tracer == nil || c.tracer.UpdatedCongestionState == nil || new == c.lastState {
-		return
-	}
-	c.tracer.UpdatedCongestionState(new)
-	c.lastState = new
-}
-
-func (c *cubicSender) SetMaxDatagramSize(s protocol.ByteCount) {
+func (c *cubicSender) SetMaxDatagramSize(s congestion.ByteCount) {
 	if s < c.maxDatagramSize {
 		panic(fmt.Sprintf("congestion BUG: decreased max datagram size from %d to %d", c.maxDatagramSize, s))
 	}
@@ -0,0 +1,305 @@
+package cubic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+)
+
+const (
+	initialCongestionWindowPackets = 10
+	defaultWindow                  = initialCongestionWindowPackets * maxDatagramSize
+)
+
+type mockClock time.Time
+
+func (c *mockClock) Now() time.Time {
+	return time.Time(*c)
+}
+
+func (c *mockClock) Advance(d time.Duration) {
+	*c = mockClock(time.Time(*c).Add(d))
+}
+
+// testRTTStats keeps the minimum of the samples, the other RTTs are the latest sample.
+type testRTTStats struct {
+	congestion.RTTStatsProvider
+	minRTT    time.Duration
+	latestRTT time.Duration
+}
+
+func (r *testRTTStats) MinRTT() time.Duration      { return r.minRTT }
+func (r *testRTTStats) LatestRTT() time.Duration   { return r.latestRTT }
+func (r *testRTTStats) SmoothedRTT() time.Duration { return r.latestRTT }
+
+func (r *testRTTStats) UpdateRTT(sendDelta, _ time.Duration, _ time.Time) {
+	r.latestRTT = sendDelta
+	if r.minRTT == 0 || sendDelta < r.minRTT {
+		r.minRTT = sendDelta
+	}
+}
+
+// senderTest drives a cubicSender like the upstream quic-go tests.
+type senderTest struct {
+	sender            *cubicSender
+	clock             *mockClock
+	rttStats          *testRTTStats
+	bytesInFlight     congestion.ByteCount
+	packetNumber      congestion.PacketNumber
+	ackedPacketNumber congestion.PacketNumber
+}
+
+func newSenderTest(reno bool) *senderTest {
+	clock := mockClock(time.Unix(1000, 0))
+	s := &senderTest{
+		clock:        &clock,
+		rttStats:     &testRTTStats{},
+		packetNumber: 1,
+	}
+	s.sender = newCubicSender(s.clock, reno, maxDatagramSize, defaultWindow, congestion.MaxCongestionWindowPackets*maxDatagramSize)
+	s.sender.SetRTTStatsProvider(s.rttStats)
+	return s
+}
+
+func (s *senderTest) sendAvailableSendWindow() int {
+	var packetsSent int
+	for s.sender.CanSend(s.bytesInFlight) {
+		s.sender.OnPacketSent(s.clock.Now(), s.bytesInFlight, s.packetNumber, maxDatagramSize, true)
+		s.packetNumber++
+		packetsSent++
+		s.bytesInFlight += maxDatagramSize
+	}
+	return packetsSent
+}
+
+func (s *senderTest) ackNPackets(n int) {
+	s.ackNPacketsWithRTT(n, 60*time.Millisecond)
+}
+
+func (s *senderTest) ackNPacketsWithRTT(n int, rtt time.Duration) {
+	s.rttStats.UpdateRTT(rtt, 0, s.clock.Now())
+	s.sender.MaybeExitSlowStart()
+	for i := 0; i < n; i++ {
+		s.ackedPacketNumber++
+		s.sender.OnPacketAcked(s.ackedPacketNumber, maxDatagramSize, s.bytesInFlight, s.clock.Now())
+	}
+	s.bytesInFlight -= congestion.ByteCount(n) * maxDatagramSize
+	s.clock.Advance(time.Millisecond)
+}
+
+func (s *senderTest) loseNPackets(n int) {
+	for i := 0; i < n; i++ {
+		s.ackedPacketNumber++
+		s.sender.OnCongestionEvent(s.ackedPacketNumber, maxDatagramSize, s.bytesInFlight)
+	}
+	s.bytesInFlight -= congestion.ByteCount(n) * maxDatagramSize
+}
+
+// losePacket does not increment ackedPacketNumber.
+func (s *senderTest) losePacket(number congestion.PacketNumber) {
+	s.sender.OnCongestionEvent(number, maxDatagramSize, s.bytesInFlight)
+	s.bytesInFlight -= maxDatagramSize
+}
+
+func TestCubicSender_ExponentialSlowStart(t *testing.T) {
+	for _, reno := range []bool{false, true} {
+		s := newSenderTest(reno)
+		const numberOfAcks = 20
+		if !s.sender.CanSend(0) {
+			t.Fatal("cannot send")
+		}
+		for i := 0; i < numberOfAcks; i++ {
+			s.sendAvailableSendWindow()
+			s.ackNPackets(2)
+		}
+		cwnd := s.sender.GetCongestionWindow()
+		if expected := defaultWindow + 2*numberOfAcks*maxDatagramSize; cwnd != expected {
+			t.Errorf("reno %t: cwnd is %d, expected %d", reno, cwnd, expected)
+		}
+		if !s.sender.InSlowStart() {
+			t.Errorf("reno %t: left slow start", reno)
+		}
+		if bandwidth := s.sender.BandwidthEstimate(); bandwidth != BandwidthFromDelta(cwnd, 60*time.Millisecond) {
+			t.Errorf("reno %t: bandwidth estimate is %d", reno, bandwidth)
+		}
+	}
+}
+
+func TestCubicSender_SlowStartPacketLossReno(t *testing.T) {
+	s := newSenderTest(true)
+	const numberOfAcks = 10
+	for i := 0; i < numberOfAcks; i++ {
+		s.sendAvailableSendWindow()
+		s.ackNPackets(2)
+	}
+	s.sendAvailableSendWindow()
+	expectedSendWindow := defaultWindow + 2*numberOfAcks*maxDatagramSize
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != expectedSendWindow {
+		t.Fatalf("cwnd is %d, expected %d", cwnd, expectedSendWindow)
+	}
+
+	// a loss ends slow start with a reduced window
+	s.loseNPackets(1)
+	packetsInRecoveryWindow := expectedSendWindow / maxDatagramSize
+	expectedSendWindow = congestion.ByteCount(float64(expectedSendWindow) * renoBeta)
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != expectedSendWindow {
+		t.Fatalf("cwnd is %d after the loss, expected %d", cwnd, expectedSendWindow)
+	}
+	if s.sender.InSlowStart() || !s.sender.InRecovery() {
+		t.Fatal("expected recovery after the loss")
+	}
+
+	// the cwnd does not grow until every packet of the recovery window is acked
+	numberOfPacketsInWindow := expectedSendWindow / maxDatagramSize
+	s.ackNPackets(int(packetsInRecoveryWindow))
+	s.sendAvailableSendWindow()
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != expectedSendWindow {
+		t.Fatalf("cwnd is %d after the recovery, expected %d", cwnd, expectedSendWindow)
+	}
+
+	// Reno grows by one packet per window of acks
+	s.ackNPackets(int(numberOfPacketsInWindow) - 2)
+	s.sendAvailableSendWindow()
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != expectedSendWindow {
+		t.Fatalf("cwnd is %d before a full window is acked, expected %d", cwnd, expectedSendWindow)
+	}
+	s.ackNPackets(1)
+	expectedSendWindow += maxDatagramSize
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != expectedSendWindow {
+		t.Fatalf("cwnd is %d after a full window is acked, expected %d", cwnd, expectedSendWindow)
+	}
+
+	// a retransmission timeout resets the hybrid slow start
+	if !s.sender.hybridSlowStart.Started() {
+		t.Fatal("hybrid slow start not started")
+	}
+	s.sender.OnRetransmissionTimeout(true)
+	if s.sender.hybridSlowStart.Started() {
+		t.Fatal("hybrid slow start not reset by the retransmission timeout")
+	}
+}
+
+func TestCubicSender_SlowStartPacketLossCubic(t *testing.T) {
+	s := newSenderTest(false)
+	for i := 0; i < 10; i++ {
+		s.sendAvailableSendWindow()
+		s.ackNPackets(2)
+	}
+	s.sendAvailableSendWindow()
+	windowBeforeLoss := s.sender.GetCongestionWindow()
+
+	// the multiplicative decrease of cubic with a single emulated connection
+	s.loseNPackets(1)
+	expectedSendWindow := congestion.ByteCount(float32(windowBeforeLoss) * beta)
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != expectedSendWindow {
+		t.Fatalf("cwnd is %d after the loss, expected %d", cwnd, expectedSendWindow)
+	}
+	if s.sender.slowStartThreshold != expectedSendWindow {
+		t.Fatalf("ssthresh is %d, expected %d", s.sender.slowStartThreshold, expectedSendWindow)
+	}
+
+	// the window grows again in congestion avoidance once the recovery is over
+	s.ackNPackets(int(windowBeforeLoss / maxDatagramSize))
+	for i := 0; i < 100; i++ {
+		s.sendAvailableSendWindow()
+		s.ackNPackets(2)
+		s.clock.Advance(10 * time.Millisecond)
+	}
+	if cwnd := s.sender.GetCongestionWindow(); cwnd <= expectedSendWindow || s.sender.InSlowStart() {
+		t.Fatalf("cwnd is %d in congestion avoidance, expected more than %d", cwnd, expectedSendWindow)
+	}
+}
+
+func TestCubicSender_RetransmissionTimeoutCongestionWindow(t *testing.T) {
+	s := newSenderTest(true)
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != defaultWindow {
+		t.Fatalf("cwnd is %d, expected %d", cwnd, defaultWindow)
+	}
+	if s.sender.slowStartThreshold != maxByteCount {
+		t.Fatalf("ssthresh is %d", s.sender.slowStartThreshold)
+	}
+	// the window decreases to the minimum and ssthresh to half of the window
+	s.sender.OnRetransmissionTimeout(true)
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != minCongestionWindowPackets*maxDatagramSize {
+		t.Errorf("cwnd is %d after the timeout", cwnd)
+	}
+	if s.sender.slowStartThreshold != 5*maxDatagramSize {
+		t.Errorf("ssthresh is %d after the timeout", s.sender.slowStartThreshold)
+	}
+}
+
+func TestCubicSender_MultipleLossesInOneWindow(t *testing.T) {
+	s := newSenderTest(true)
+	s.sendAvailableSendWindow()
+	initialWindow := s.sender.GetCongestionWindow()
+	s.losePacket(s.ackedPacketNumber + 1)
+	postLossWindow := s.sender.GetCongestionWindow()
+	if postLossWindow >= initialWindow {
+		t.Fatalf("cwnd is %d after the loss, expected less than %d", postLossWindow, initialWindow)
+	}
+	// losses of packets sent before the first loss do not decrease the window again
+	s.losePacket(s.ackedPacketNumber + 3)
+	s.losePacket(s.packetNumber - 1)
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != postLossWindow {
+		t.Fatalf("cwnd is %d after losses in the same window, expected %d", cwnd, postLossWindow)
+	}
+	// a packet sent after the first loss decreases it
+	s.losePacket(s.packetNumber)
+	if cwnd := s.sender.GetCongestionWindow(); cwnd >= postLossWindow {
+		t.Fatalf("cwnd is %d after a later loss, expected less than %d", cwnd, postLossWindow)
+	}
+}
+
+func TestCubicSender_HybridSlowStartExit(t *testing.T) {
+	for _, test := range []struct {
+		rtt         time.Duration
+		inSlowStart bool
+	}{
+		// a RTT increase below 1/8 of the min RTT is not a queue
+		{rtt: 65 * time.Millisecond, inSlowStart: true},
+		{rtt: 80 * time.Millisecond, inSlowStart: false},
+	} {
+		s := newSenderTest(false)
+		// end the first round with a window above the hybridStartLowWindow
+		s.sendAvailableSendWindow()
+		s.ackNPackets(initialCongestionWindowPackets)
+		s.sendAvailableSendWindow()
+		s.ackNPackets(1)
+		// the hybrid slow start compares the first samples of the next round
+		for i := 0; i < int(hybridStartMinSamples)-1; i++ {
+			s.ackNPacketsWithRTT(1, test.rtt)
+			if !s.sender.InSlowStart() {
+				t.Fatalf("RTT %s: left slow start after %d samples", test.rtt, i+1)
+			}
+		}
+		cwnd := s.sender.GetCongestionWindow()
+		s.ackNPacketsWithRTT(1, test.rtt)
+		if s.sender.InSlowStart() != test.inSlowStart {
+			t.Fatalf("RTT %s: in slow start: %t, expected %t", test.rtt, s.sender.InSlowStart(), test.inSlowStart)
+		}
+		if !test.inSlowStart && s.sender.slowStartThreshold != cwnd {
+			t.Fatalf("RTT %s: ssthresh is %d, expected the window %d", test.rtt, s.sender.slowStartThreshold, cwnd)
+		}
+	}
+}
+
+func TestCubicSender_ResetAfterConnectionMigration(t *testing.T) {
+	s := newSenderTest(true)
+	for i := 0; i < 10; i++ {
+		s.sendAvailableSendWindow()
+		s.ackNPackets(2)
+	}
+	s.sendAvailableSendWindow()
+	s.loseNPackets(1)
+	if s.sender.GetCongestionWindow() == defaultWindow || s.sender.InSlowStart() {
+		t.Fatal("expected a reduced window after the loss")
+	}
+	s.sender.OnConnectionMigration()
+	if cwnd := s.sender.GetCongestionWindow(); cwnd != defaultWindow {
+		t.Errorf("cwnd is %d after the migration, expected %d", cwnd, defaultWindow)
+	}
+	if !s.sender.InSlowStart() || s.sender.InRecovery() {
+		t.Error("expected slow start after the migration")
+	}
+}
@@ -0,0 +1,92 @@
+package cubic
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+)
+
+func TestCubic_CongestionWindowAfterPacketLoss(t *testing.T) {
+	clock := mockClock(time.Unix(1000, 0))
+	cubic := NewCubic(&clock)
+
+	currentCwnd := 100 * maxDatagramSize
+	expectedCwnd := congestion.ByteCount(float32(currentCwnd) * beta)
+	if cwnd := cubic.CongestionWindowAfterPacketLoss(currentCwnd); cwnd != expectedCwnd {
+		t.Fatalf("cwnd is %d after the first loss, expected %d", cwnd, expectedCwnd)
+	}
+	if cubic.lastMaxCongestionWindow != currentCwnd {
+		t.Fatalf("last max is %d, expected %d", cubic.lastMaxCongestionWindow, currentCwnd)
+	}
+
+	// a loss below the last max backs off the last max further
+	currentCwnd = 80 * maxDatagramSize
+	expectedCwnd = congestion.ByteCount(float32(currentCwnd) * beta)
+	if cwnd := cubic.CongestionWindowAfterPacketLoss(currentCwnd); cwnd != expectedCwnd {
+		t.Fatalf("cwnd is %d after the second loss, expected %d", cwnd, expectedCwnd)
+	}
+	if expected := congestion.ByteCount(float32(currentCwnd) * betaLastMax); cubic.lastMaxCongestionWindow != expected {
+		t.Fatalf("last max is %d, expected %d", cubic.lastMaxCongestionWindow, expected)
+	}
+}
+
+func TestCubic_RenoFriendlyRegion(t *testing.T) {
+	clock := mockClock(time.Unix(1000, 0))
+	cubic := NewCubic(&clock)
+	renoCwnd := func(currentCwnd congestion.ByteCount) congestion.ByteCount {
+		return currentCwnd + congestion.ByteCount(float32(maxDatagramSize)*cubic.alpha()*float32(maxDatagramSize)/float32(currentCwnd))
+	}
+
+	const rttMin = 100 * time.Millisecond
+	currentCwnd := 10 * maxDatagramSize
+	clock.Advance(time.Millisecond)
+	expectedFirstCwnd := renoCwnd(currentCwnd)
+	currentCwnd = cubic.CongestionWindowAfterAck(maxDatagramSize, currentCwnd, rttMin, clock.Now())
+	if currentCwnd != expectedFirstCwnd {
+		t.Fatalf("cwnd is %d after the first ack, expected %d", currentCwnd, expectedFirstCwnd)
+	}
+
+	// the Reno estimate is larger than the cubic curve until they meet
+	rttMinS := rttMin.Seconds()
+	maxRenoRtts := int(math.Sqrt(float64(beta)/(0.4*rttMinS*rttMinS*rttMinS))) - 2
+	for i := 0; i < maxRenoRtts; i++ {
+		numAcksThisEpoch := int(float32(currentCwnd/maxDatagramSize) / cubic.alpha())
+		initialCwndThisEpoch := currentCwnd
+		for n := 0; n < numAcksThisEpoch; n++ {
+			expectedNextCwnd := renoCwnd(currentCwnd)
+			currentCwnd = cubic.CongestionWindowAfterAck(maxDatagramSize, currentCwnd, rttMin, clock.Now())
+			if currentCwnd != expectedNextCwnd {
+				t.Fatalf("RTT %d: cwnd is %d, expected %d", i, currentCwnd, expectedNextCwnd)
+			}
+		}
+		// about one packet per RTT, the byte-wise estimate may be off by half a packet
+		change := currentCwnd - initialCwndThisEpoch
+		if change < maxDatagramSize/2 || change > maxDatagramSize*3/2 {
+			t.Fatalf("RTT %d: cwnd grew by %d", i, change)
+		}
+		clock.Advance(rttMin)
+	}
+}
+
+func TestCubic_ConvexRegion(t *testing.T) {
+	clock := mockClock(time.Unix(1000, 0))
+	cubic := NewCubic(&clock)
+
+	const rttMin = 100 * time.Millisecond
+	currentCwnd := cubic.CongestionWindowAfterPacketLoss(100 * maxDatagramSize)
+	initialCwnd := currentCwnd
+	// the window is concave up to the last max and convex beyond it
+	for i := 0; i < 100; i++ {
+		for n := congestion.ByteCount(0); n < currentCwnd/maxDatagramSize; n++ {
+			currentCwnd = cubic.CongestionWindowAfterAck(maxDatagramSize, currentCwnd, rttMin, clock.Now())
+		}
+		clock.Advance(rttMin)
+	}
+	// Reno would have grown by alpha packets per RTT
+	renoCwnd := initialCwnd + congestion.ByteCount(100*cubic.alpha())*maxDatagramSize
+	if currentCwnd <= 100*maxDatagramSize || currentCwnd <= renoCwnd {
+		t.Fatalf("cwnd is %d after 10 s, expected more than the last max and the Reno window %d", currentCwnd, renoCwnd)
+	}
+}
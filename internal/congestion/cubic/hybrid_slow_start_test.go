@@ -0,0 +1,98 @@
+package cubic
+
+import (
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+)
+
+func TestHybridSlowStart_Rounds(t *testing.T) {
+	slowStart := HybridSlowStart{}
+	packetNumber := congestion.PacketNumber(1)
+	endPacketNumber := congestion.PacketNumber(3)
+	slowStart.StartReceiveRound(endPacketNumber)
+
+	packetNumber++
+	if slowStart.IsEndOfRound(packetNumber) {
+		t.Fatalf("packet %d ended the round", packetNumber)
+	}
+	// the end packet number is still part of the round
+	packetNumber++
+	if slowStart.IsEndOfRound(packetNumber) {
+		t.Fatalf("packet %d ended the round", packetNumber)
+	}
+	packetNumber++
+	if !slowStart.IsEndOfRound(packetNumber) {
+		t.Fatalf("packet %d did not end the round", packetNumber)
+	}
+
+	// test without a new registered end packet number
+	packetNumber++
+	if !slowStart.IsEndOfRound(packetNumber) {
+		t.Fatalf("packet %d did not end the round", packetNumber)
+	}
+
+	endPacketNumber = 20
+	slowStart.StartReceiveRound(endPacketNumber)
+	for packetNumber < endPacketNumber {
+		packetNumber++
+		if slowStart.IsEndOfRound(packetNumber) {
+			t.Fatalf("packet %d ended the round", packetNumber)
+		}
+	}
+	packetNumber++
+	if !slowStart.IsEndOfRound(packetNumber) {
+		t.Fatalf("packet %d did not end the round", packetNumber)
+	}
+}
+
+func TestHybridSlowStart_Delay(t *testing.T) {
+	slowStart := HybridSlowStart{}
+	const rtt = 60 * time.Millisecond
+	// We expect to detect the increase at +1/8 of the RTT; hence at a typical
+	// RTT of 60ms the detection will happen at 67.5 ms.
+
+	endPacketNumber := congestion.PacketNumber(1)
+	endPacketNumber++
+	slowStart.StartReceiveRound(endPacketNumber)
+
+	// Will not trigger since our lowest RTT in our burst is the same as the long
+	// term RTT provided.
+	for n := 0; n < int(hybridStartMinSamples); n++ {
+		if slowStart.ShouldExitSlowStart(rtt+time.Duration(n)*time.Millisecond, rtt, 100) {
+			t.Fatalf("exited slow start after sample %d of the first round", n)
+		}
+	}
+	endPacketNumber++
+	slowStart.StartReceiveRound(endPacketNumber)
+	for n := 1; n < int(hybridStartMinSamples); n++ {
+		if slowStart.ShouldExitSlowStart(rtt+(time.Duration(n)+10)*time.Millisecond, rtt, 100) {
+			t.Fatalf("exited slow start after sample %d of the second round", n)
+		}
+	}
+	// Expect to trigger since all packets in this burst was above the long term
+	// RTT provided.
+	if !slowStart.ShouldExitSlowStart(rtt+10*time.Millisecond, rtt, 100) {
+		t.Fatal("did not exit slow start")
+	}
+}
+
+func TestHybridSlowStart_LowWindow(t *testing.T) {
+	slowStart := HybridSlowStart{}
+	const rtt = 60 * time.Millisecond
+	slowStart.StartReceiveRound(1)
+	// the delay increase is found, but the window is below the hybridStartLowWindow
+	for n := 0; n < int(hybridStartMinSamples); n++ {
+		if slowStart.ShouldExitSlowStart(2*rtt, rtt, hybridStartLowWindow-1) {
+			t.Fatalf("exited slow start after sample %d", n)
+		}
+	}
+	if !slowStart.ShouldExitSlowStart(2*rtt, rtt, hybridStartLowWindow) {
+		t.Fatal("did not exit slow start after the window grew")
+	}
+	slowStart.Restart()
+	if slowStart.Started() || slowStart.ShouldExitSlowStart(2*rtt, rtt, hybridStartLowWindow) {
+		t.Fatal("the restart did not reset the hybrid slow start")
+	}
+}
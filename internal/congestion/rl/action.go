@@ -0,0 +1,130 @@
+package rl
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+)
+
+const (
+	// ActionSpaceDiscrete adds Values[Action] packets to the cwnd.
+	ActionSpaceDiscrete = "discrete"
+	// ActionSpaceMultiplicative multiplies the cwnd by Values[Action].
+	ActionSpaceMultiplicative = "multiplicative"
+	// ActionSpacePacing sets the pacing rate to Value bytes per second, the cwnd allows two times the BDP.
+	ActionSpacePacing = "pacing"
+	// ActionSpaceContinuous multiplies the cwnd by 2^Value, Value is typically in [-1, 1].
+	ActionSpaceContinuous = "continuous"
+)
+
+// minCongestionWindowPackets is the default lower bound of the cwnd.
+const minCongestionWindowPackets = 2
+
+// maxPacingRate is the highest pacing rate the agent can set, in bytes per second.
+const maxPacingRate = 1 << 40
+
+// pacingCongestionWindowGain is the cwnd in multiples of the BDP if the agent sets the pacing rate.
+const pacingCongestionWindowGain = 2
+
+var defaultActionValues = map[string][]float64{
+	ActionSpaceDiscrete:       {-3, -1, 0, 1, 3},
+	ActionSpaceMultiplicative: {0.5, 0.8, 1, 1.25, 2},
+}
+
+// ActionSpace defines how the actions of the agent change the cwnd or the pacing rate.
+type ActionSpace struct {
+	Type string
+	// indexed by ActionMsg.Action for the discrete and multiplicative action spaces
+	Values []float64
+	// bounds of the cwnd in bytes, 0 for 2 packets and the maximum of quic-go
+	MinCwnd congestion.ByteCount
+	MaxCwnd congestion.ByteCount
+}
+
+// NewActionSpace validates the action space, missing values are replaced by the defaults of the type.
+func NewActionSpace(actionSpaceType string, values []float64, minCwnd, maxCwnd uint64) (*ActionSpace, error) {
+	a := &ActionSpace{
+		Type:    actionSpaceType,
+		Values:  values,
+		MinCwnd: congestion.ByteCount(minCwnd),
+		MaxCwnd: congestion.ByteCount(maxCwnd),
+	}
+	switch actionSpaceType {
+	case ActionSpaceDiscrete, ActionSpaceMultiplicative:
+		if len(a.Values) == 0 {
+			a.Values = defaultActionValues[actionSpaceType]
+		}
+	case ActionSpacePacing, ActionSpaceContinuous:
+		if len(a.Values) != 0 {
+			return nil, fmt.Errorf("the %s action space takes no values", actionSpaceType)
+		}
+	default:
+		return nil, fmt.Errorf("invalid action space: %s", actionSpaceType)
+	}
+	if actionSpaceType == ActionSpaceMultiplicative {
+		for _, v := range a.Values {
+			if v <= 0 {
+				return nil, fmt.Errorf("multiplicative action values must be positive: %v", v)
+			}
+		}
+	}
+	if a.MaxCwnd != 0 && a.MaxCwnd < a.MinCwnd {
+		return nil, fmt.Errorf("max cwnd %d is smaller than min cwnd %d", a.MaxCwnd, a.MinCwnd)
+	}
+	return a, nil
+}
+
+// DefaultActionSpace are the five discrete cwnd deltas of the first agents.
+func DefaultActionSpace() *ActionSpace {
+	return &ActionSpace{
+		Type:   ActionSpaceDiscrete,
+		Values: defaultActionValues[ActionSpaceDiscrete],
+	}
+}
+
+// apply returns the new cwnd and pacing rate, a pacing rate of 0 means the pacing rate is derived from the cwnd.
+func (a *ActionSpace) apply(action *ActionMsg, cwnd, maxDatagramSize congestion.ByteCount, smoothedRTT time.Duration) (congestion.ByteCount, congestion.ByteCount, error) {
+	// computed as float, so overflows are clamped as well
+	target := float64(cwnd)
+	var pacingRate congestion.ByteCount
+	switch a.Type {
+	case ActionSpaceDiscrete, ActionSpaceMultiplicative:
+		if action.Action < 0 || action.Action >= len(a.Values) {
+			return 0, 0, fmt.Errorf("action %d out of range [0, %d)", action.Action, len(a.Values))
+		}
+		if a.Type == ActionSpaceDiscrete {
+			target += a.Values[action.Action] * float64(maxDatagramSize)
+		} else {
+			target *= a.Values[action.Action]
+		}
+	case ActionSpaceContinuous:
+		target *= math.Pow(2, action.Value)
+	case ActionSpacePacing:
+		if action.Value <= 0 || action.Value > maxPacingRate {
+			return 0, 0, fmt.Errorf("invalid pacing rate: %v", action.Value)
+		}
+		pacingRate = congestion.ByteCount(action.Value)
+		target = action.Value * smoothedRTT.Seconds() * pacingCongestionWindowGain
+	}
+	return a.clamp(target, maxDatagramSize), pacingRate, nil
+}
+
+func (a *ActionSpace) clamp(cwnd float64, maxDatagramSize congestion.ByteCount) congestion.ByteCount {
+	minCwnd := a.MinCwnd
+	if minCwnd == 0 {
+		minCwnd = minCongestionWindowPackets * maxDatagramSize
+	}
+	maxCwnd := a.MaxCwnd
+	if maxCwnd == 0 {
+		maxCwnd = congestion.MaxCongestionWindowPackets * maxDatagramSize
+	}
+	if math.IsNaN(cwnd) || cwnd < float64(minCwnd) {
+		return minCwnd
+	}
+	if cwnd > float64(maxCwnd) {
+		return maxCwnd
+	}
+	return congestion.ByteCount(cwnd)
+}
@@ -0,0 +1,154 @@
+package rl
+
+import (
+	"context"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+)
+
+func TestActionSpace_Apply(t *testing.T) {
+	const mss = congestion.ByteCount(1000)
+	const maxCwnd = congestion.MaxCongestionWindowPackets * mss
+	discrete := DefaultActionSpace()
+	multiplicative, err := NewActionSpace(ActionSpaceMultiplicative, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounded, err := NewActionSpace(ActionSpaceMultiplicative, nil, 5000, 20_000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	continuous, err := NewActionSpace(ActionSpaceContinuous, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pacing, err := NewActionSpace(ActionSpacePacing, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name       string
+		space      *ActionSpace
+		action     ActionMsg
+		cwnd       congestion.ByteCount
+		expected   congestion.ByteCount
+		pacingRate congestion.ByteCount
+		err        bool
+	}{
+		{name: "discrete decrease", space: discrete, action: ActionMsg{Action: 0}, cwnd: 10_000, expected: 7000},
+		{name: "discrete keep", space: discrete, action: ActionMsg{Action: 2}, cwnd: 10_000, expected: 10_000},
+		{name: "discrete increase", space: discrete, action: ActionMsg{Action: 4}, cwnd: 10_000, expected: 13_000},
+		{name: "discrete min", space: discrete, action: ActionMsg{Action: 0}, cwnd: 4000, expected: minCongestionWindowPackets * mss},
+		{name: "discrete max", space: discrete, action: ActionMsg{Action: 4}, cwnd: maxCwnd, expected: maxCwnd},
+		{name: "discrete negative index", space: discrete, action: ActionMsg{Action: -1}, cwnd: 10_000, err: true},
+		{name: "discrete index too large", space: discrete, action: ActionMsg{Action: 5}, cwnd: 10_000, err: true},
+		{name: "multiplicative decrease", space: multiplicative, action: ActionMsg{Action: 0}, cwnd: 10_000, expected: 5000},
+		{name: "multiplicative increase", space: multiplicative, action: ActionMsg{Action: 3}, cwnd: 10_000, expected: 12_500},
+		{name: "multiplicative max", space: multiplicative, action: ActionMsg{Action: 4}, cwnd: maxCwnd, expected: maxCwnd},
+		{name: "multiplicative index too large", space: multiplicative, action: ActionMsg{Action: 5}, cwnd: 10_000, err: true},
+		{name: "bounded min", space: bounded, action: ActionMsg{Action: 0}, cwnd: 8000, expected: 5000},
+		{name: "bounded max", space: bounded, action: ActionMsg{Action: 4}, cwnd: 15_000, expected: 20_000},
+		{name: "continuous halve", space: continuous, action: ActionMsg{Value: -1}, cwnd: 10_000, expected: 5000},
+		{name: "continuous keep", space: continuous, action: ActionMsg{Value: 0}, cwnd: 10_000, expected: 10_000},
+		{name: "continuous double", space: continuous, action: ActionMsg{Value: 1}, cwnd: 10_000, expected: 20_000},
+		{name: "continuous min", space: continuous, action: ActionMsg{Value: -10}, cwnd: 10_000, expected: minCongestionWindowPackets * mss},
+		{name: "continuous overflow", space: continuous, action: ActionMsg{Value: 1e6}, cwnd: 10_000, expected: maxCwnd},
+		{name: "continuous NaN", space: continuous, action: ActionMsg{Value: math.NaN()}, cwnd: 10_000, expected: minCongestionWindowPackets * mss},
+		// two times the BDP of 1 MB/s and 100 ms
+		{name: "pacing", space: pacing, action: ActionMsg{Value: 1_000_000}, cwnd: 10_000, expected: 200_000, pacingRate: 1_000_000},
+		{name: "pacing min", space: pacing, action: ActionMsg{Value: 1}, cwnd: 10_000, expected: minCongestionWindowPackets * mss, pacingRate: 1},
+		{name: "pacing max", space: pacing, action: ActionMsg{Value: maxPacingRate}, cwnd: 10_000, expected: maxCwnd, pacingRate: maxPacingRate},
+		{name: "pacing zero", space: pacing, action: ActionMsg{Value: 0}, cwnd: 10_000, err: true},
+		{name: "pacing too high", space: pacing, action: ActionMsg{Value: 2 * maxPacingRate}, cwnd: 10_000, err: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			cwnd, pacingRate, err := test.space.apply(&test.action, test.cwnd, mss, 100*time.Millisecond)
+			if test.err {
+				if err == nil {
+					t.Fatalf("expected an error, got cwnd %d", cwnd)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if cwnd != test.expected {
+				t.Errorf("got cwnd %d, expected %d", cwnd, test.expected)
+			}
+			if pacingRate != test.pacingRate {
+				t.Errorf("got pacing rate %d, expected %d", pacingRate, test.pacingRate)
+			}
+		})
+	}
+}
+
+func TestActionSpace_Clamp(t *testing.T) {
+	const mss = congestion.ByteCount(1000)
+	for _, test := range []struct {
+		name             string
+		minCwnd, maxCwnd congestion.ByteCount
+		cwnd             float64
+		expected         congestion.ByteCount
+	}{
+		{name: "default min", cwnd: 1, expected: minCongestionWindowPackets * mss},
+		{name: "default max", cwnd: math.Inf(1), expected: congestion.MaxCongestionWindowPackets * mss},
+		{name: "negative", cwnd: -1, expected: minCongestionWindowPackets * mss},
+		{name: "NaN", cwnd: math.NaN(), expected: minCongestionWindowPackets * mss},
+		{name: "within bounds", minCwnd: 5000, maxCwnd: 20_000, cwnd: 12_345.6, expected: 12_345},
+		{name: "at min", minCwnd: 5000, maxCwnd: 20_000, cwnd: 5000, expected: 5000},
+		{name: "below min", minCwnd: 5000, maxCwnd: 20_000, cwnd: 4999, expected: 5000},
+		{name: "at max", minCwnd: 5000, maxCwnd: 20_000, cwnd: 20_000, expected: 20_000},
+		{name: "above max", minCwnd: 5000, maxCwnd: 20_000, cwnd: 20_001, expected: 20_000},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			a := &ActionSpace{Type: ActionSpaceContinuous, MinCwnd: test.minCwnd, MaxCwnd: test.maxCwnd}
+			if cwnd := a.clamp(test.cwnd, mss); cwnd != test.expected {
+				t.Errorf("got %d, expected %d", cwnd, test.expected)
+			}
+		})
+	}
+}
+
+func TestRLSender_PacingRate(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	actionSpace, err := NewActionSpace(ActionSpacePacing, nil, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sender := NewRLSender(ctx, NewChannelTransport(), "conn", nil, actionSpace, nil, nil, nil)
+	sender.SetRTTStatsProvider(&fixedRTTStats{rtt: 10 * time.Millisecond})
+
+	// derived from the cwnd until the agent sets it
+	cwnd := sender.GetCongestionWindow()
+	if expected := congestion.ByteCount(float64(cwnd)/0.01) * 5 / 4; sender.pacingRate() != expected {
+		t.Errorf("got pacing rate %d, expected %d", sender.pacingRate(), expected)
+	}
+	cwnd, err = sender.applyAction(&ActionMsg{Value: 1_000_000})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cwnd != 20_000 || sender.pacingRate() != 1_000_000 {
+		t.Errorf("got cwnd %d and pacing rate %d, expected 20000 and 1000000", cwnd, sender.pacingRate())
+	}
+	// an invalid action keeps the pacing rate
+	if _, err = sender.applyAction(&ActionMsg{Value: -1}); err == nil {
+		t.Error("expected an error for a negative pacing rate")
+	}
+	if sender.pacingRate() != 1_000_000 {
+		t.Errorf("got pacing rate %d after the invalid action", sender.pacingRate())
+	}
+
+	// other action spaces derive it from the cwnd again
+	sender.actionSpace = DefaultActionSpace()
+	cwnd, err = sender.applyAction(&ActionMsg{Action: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := congestion.ByteCount(float64(cwnd)/0.01) * 5 / 4; sender.pacingRate() != expected {
+		t.Errorf("got pacing rate %d, expected %d", sender.pacingRate(), expected)
+	}
+}
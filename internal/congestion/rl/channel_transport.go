@@ -0,0 +1,82 @@
+package rl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// channelTransportBuffer is the number of messages buffered per listener.
+const channelTransportBuffer = 64
+
+// ChannelTransport is an in-process Transport for agents written in Go and for tests.
+// Like Redis pub/sub, messages are only delivered to current listeners.
+type ChannelTransport struct {
+	mutex     sync.Mutex
+	listeners map[string]map[chan string]struct{}
+	closed    bool
+}
+
+func NewChannelTransport() *ChannelTransport {
+	return &ChannelTransport{
+		listeners: make(map[string]map[chan string]struct{}),
+	}
+}
+
+func (t *ChannelTransport) Publish(channel, message string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for ch := range t.listeners[channel] {
+		select {
+		case ch <- message:
+		default:
+			fmt.Printf("rl: listener of %s is too slow, drop message\n", channel)
+		}
+	}
+	return nil
+}
+
+// Listen returns a channel that is closed when ctx is done or the transport is closed.
+func (t *ChannelTransport) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	ch := make(chan string, channelTransportBuffer)
+	t.mutex.Lock()
+	if t.closed {
+		t.mutex.Unlock()
+		return nil, errors.New("transport closed")
+	}
+	if t.listeners[channel] == nil {
+		t.listeners[channel] = make(map[chan string]struct{})
+	}
+	t.listeners[channel][ch] = struct{}{}
+	t.mutex.Unlock()
+	go func() {
+		<-ctx.Done()
+		t.mutex.Lock()
+		defer t.mutex.Unlock()
+		// already closed with the transport
+		if _, ok := t.listeners[channel][ch]; !ok {
+			return
+		}
+		delete(t.listeners[channel], ch)
+		if len(t.listeners[channel]) == 0 {
+			delete(t.listeners, channel)
+		}
+		close(ch)
+	}()
+	return ch, nil
+}
+
+// Close closes the channels of all listeners.
+func (t *ChannelTransport) Close() error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.closed = true
+	for channel, listeners := range t.listeners {
+		for ch := range listeners {
+			close(ch)
+		}
+		delete(t.listeners, channel)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package rl
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// waitForListener waits until ListenAction subscribed to the channel.
+func waitForListener(t *testing.T, transport *ChannelTransport, channel string) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		transport.mutex.Lock()
+		n := len(transport.listeners[channel])
+		transport.mutex.Unlock()
+		if n > 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("no listener on %s", channel)
+}
+
+func TestChannelTransport_StateAndAction(t *testing.T) {
+	transport := NewChannelTransport()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	registry, err := transport.Listen(ctx, RegistryChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	qm := NewQuicMqManager(transport, "conn1")
+	states, err := transport.Listen(ctx, qm.pubChannel)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go qm.ListenAction(ctx)
+	waitForListener(t, transport, qm.subChannel)
+
+	err = qm.PublishRegistry(RegistryEventNew)
+	if err != nil {
+		t.Fatal(err)
+	}
+	registryMsg := RegistryMsg{}
+	err = json.Unmarshal([]byte(<-registry), &registryMsg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if registryMsg.Event != RegistryEventNew || registryMsg.StateChannel != "/conn1/state" || registryMsg.ActionChannel != "/conn1/action" {
+		t.Fatalf("unexpected registry message: %+v", registryMsg)
+	}
+
+	err = qm.PublishState(&StateMsg{Cwnd: 12520, Rtt: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := StateMsg{}
+	err = json.Unmarshal([]byte(<-states), &state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Cwnd != 12520 {
+		t.Fatalf("unexpected state: %+v", state)
+	}
+
+	// an action for an older state is ignored
+	for _, action := range []ActionMsg{{Seq: state.Seq - 1, Action: 1}, {Seq: state.Seq, Action: 3}} {
+		body, _ := json.Marshal(&action)
+		err = transport.Publish(registryMsg.ActionChannel, string(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case action := <-qm.GetActionCh():
+		if action.Seq != state.Seq || action.Action != 3 {
+			t.Fatalf("unexpected action: %+v", action)
+		}
+	case <-ctx.Done():
+		t.Fatal("no action received")
+	}
+
+	cancel()
+	if _, ok := <-qm.GetActionCh(); ok {
+		t.Fatal("expected closed action channel")
+	}
+}
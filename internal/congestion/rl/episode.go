@@ -0,0 +1,123 @@
+package rl
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// EpisodeRecord is one step of a connection in the episode log.
+// The fields are flat, so the JSONL file can be loaded as a table, e.g. by pandas or converted to Parquet.
+// The reward of a state is the outcome of the action of the previous state.
+type EpisodeRecord struct {
+	ConnectionID string `json:"connection_id"`
+	// unix time of the state in microseconds
+	Time int64 `json:"time"`
+	StateMsg
+	// nil if no action was applied
+	Action      *int     `json:"action"`
+	ActionValue *float64 `json:"action_value"`
+	// set if the default action of the step mode was applied
+	ActionTimeout bool `json:"action_timeout"`
+}
+
+// EpisodeLog writes the records of all connections to one JSONL file.
+type EpisodeLog struct {
+	mutex sync.Mutex
+	file  *os.File
+}
+
+// NewEpisodeLog creates or truncates the file.
+func NewEpisodeLog(path string) (*EpisodeLog, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	return &EpisodeLog{
+		file: file,
+	}, nil
+}
+
+// Write appends the record immediately, so the records of finished connections can be read while the server runs.
+func (e *EpisodeLog) Write(record *EpisodeRecord) error {
+	body, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	_, err = e.file.Write(append(body, '\n'))
+	return err
+}
+
+func (e *EpisodeLog) Close() error {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.file.Close()
+}
+
+// newEpisodeRecord starts the record of a published state.
+func newEpisodeRecord(connectionID string, now time.Time, msg *StateMsg) *EpisodeRecord {
+	return &EpisodeRecord{
+		ConnectionID: connectionID,
+		Time:         now.UnixMicro(),
+		StateMsg:     *msg,
+	}
+}
+
+// setAction records the applied action.
+func (r *EpisodeRecord) setAction(action *ActionMsg, timeout bool) {
+	index := action.Action
+	value := action.Value
+	r.Action = &index
+	r.ActionValue = &value
+	r.ActionTimeout = timeout
+}
+
+// recordState writes the record of the previous state and starts the record of msg.
+func (b *RLSender) recordState(now time.Time, msg *StateMsg) {
+	if b.episodeLog == nil {
+		return
+	}
+	b.episodeMutex.Lock()
+	defer b.episodeMutex.Unlock()
+	b.writeEpisodeRecord()
+	b.episodeRecord = newEpisodeRecord(b.mqManager.connectionID, now, msg)
+}
+
+// recordAction adds the action to the record of its state.
+// Default actions of the step mode are recorded on timeout, as they have no Seq.
+func (b *RLSender) recordAction(action *ActionMsg, timeout bool) {
+	if b.episodeLog == nil {
+		return
+	}
+	b.episodeMutex.Lock()
+	defer b.episodeMutex.Unlock()
+	if b.episodeRecord != nil && b.episodeRecord.Action == nil && (timeout || action.Seq == b.episodeRecord.Seq) {
+		b.episodeRecord.setAction(action, timeout)
+	}
+}
+
+// flushEpisode writes the record of the last state when the connection is closed.
+func (b *RLSender) flushEpisode() {
+	if b.episodeLog == nil {
+		return
+	}
+	b.episodeMutex.Lock()
+	defer b.episodeMutex.Unlock()
+	b.writeEpisodeRecord()
+}
+
+// writeEpisodeRecord requires b.episodeMutex.
+func (b *RLSender) writeEpisodeRecord() {
+	if b.episodeRecord == nil {
+		return
+	}
+	err := b.episodeLog.Write(b.episodeRecord)
+	if err != nil {
+		fmt.Println("quic: failed to write episode record:", err)
+	}
+	b.episodeRecord = nil
+}
@@ -0,0 +1,101 @@
+package rl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+// GRPCExchangeMethod is a bidirectional streaming method of the agent exchanging Envelopes.
+// Envelopes are encoded as JSON, with the gRPC content subtype json.
+const GRPCExchangeMethod = "/qperf.rl.Agent/Exchange"
+
+// jsonCodec encodes gRPC messages as JSON, so no generated protobuf code is needed.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v any) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v any) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return "json"
+}
+
+// GRPCTransport connects to an agent serving GRPCExchangeMethod.
+type GRPCTransport struct {
+	conn      *grpc.ClientConn
+	stream    grpc.ClientStream
+	cancel    context.CancelFunc
+	sendMutex sync.Mutex
+	// dispatches received messages to the listeners
+	received *ChannelTransport
+}
+
+func NewGRPCTransport(address string) (*GRPCTransport, error) {
+	conn, err := grpc.NewClient(address,
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(jsonCodec{})),
+	)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, err := conn.NewStream(ctx, &grpc.StreamDesc{
+		StreamName:    "Exchange",
+		ServerStreams: true,
+		ClientStreams: true,
+	}, GRPCExchangeMethod)
+	if err != nil {
+		cancel()
+		_ = conn.Close()
+		return nil, err
+	}
+	t := &GRPCTransport{
+		conn:     conn,
+		stream:   stream,
+		cancel:   cancel,
+		received: NewChannelTransport(),
+	}
+	go t.receive()
+	return t, nil
+}
+
+// receive dispatches the received messages until the stream is closed, then the listeners are closed.
+func (t *GRPCTransport) receive() {
+	defer t.received.Close()
+	for {
+		envelope := Envelope{}
+		err := t.stream.RecvMsg(&envelope)
+		if err != nil {
+			fmt.Println("rl: grpc stream closed:", err)
+			return
+		}
+		_ = t.received.Publish(envelope.Channel, string(envelope.Message))
+	}
+}
+
+func (t *GRPCTransport) Publish(channel, message string) error {
+	t.sendMutex.Lock()
+	defer t.sendMutex.Unlock()
+	return t.stream.SendMsg(&Envelope{
+		Channel: channel,
+		Message: json.RawMessage(message),
+	})
+}
+
+func (t *GRPCTransport) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	return t.received.Listen(ctx, channel)
+}
+
+func (t *GRPCTransport) Close() error {
+	t.cancel()
+	return t.conn.Close()
+}
@@ -0,0 +1,48 @@
+package rl
+
+import (
+	"net"
+	"testing"
+
+	"google.golang.org/grpc"
+)
+
+func TestGRPCTransport_RoundTrip(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the agent serves GRPCExchangeMethod with the JSON codec
+	server := grpc.NewServer(
+		grpc.ForceServerCodec(jsonCodec{}),
+		grpc.UnknownServiceHandler(func(_ any, stream grpc.ServerStream) error {
+			method, _ := grpc.MethodFromServerStream(stream)
+			if method != GRPCExchangeMethod {
+				t.Errorf("unexpected method %s", method)
+			}
+			for {
+				envelope := Envelope{}
+				err := stream.RecvMsg(&envelope)
+				if err != nil {
+					return nil
+				}
+				reply := echoAction(envelope)
+				err = stream.SendMsg(&reply)
+				if err != nil {
+					return err
+				}
+			}
+		}),
+	)
+	go func() {
+		_ = server.Serve(listener)
+	}()
+	defer server.Stop()
+
+	transport, err := NewTransport("grpc://" + listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+	testRoundTrip(t, transport)
+}
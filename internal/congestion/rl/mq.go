@@ -7,48 +7,122 @@ import (
 	"github.com/apernet/quic-go/congestion"
 	"github.com/go-redis/redis/v8"
 	"math/rand"
+	"time"
 )
 
+// StateMsg is the observation published every interval.
+// Counters cover the time since the previous StateMsg, RTTs are in microseconds and rates in bytes per second.
 type StateMsg struct {
-	Seq  int                  `json:"seq"`
+	Seq int `json:"seq"`
+	// congestion window in bytes
 	Cwnd congestion.ByteCount `json:"cwnd"`
-	Rtt  int64                `json:"rtt"`
-	FIN  bool                 `json:"FIN,omitempty"`
+	// smoothed RTT
+	Rtt           int64                `json:"rtt"`
+	MinRtt        int64                `json:"min_rtt"`
+	LatestRtt     int64                `json:"latest_rtt"`
+	RttVar        int64                `json:"rtt_var"`
+	AckedBytes    uint64               `json:"acked_bytes"`
+	AckedPackets  uint64               `json:"acked_packets"`
+	LostBytes     uint64               `json:"lost_bytes"`
+	LostPackets   uint64               `json:"lost_packets"`
+	DeliveryRate  uint64               `json:"delivery_rate"`
+	BytesInFlight congestion.ByteCount `json:"bytes_in_flight"`
+	PacingRate    congestion.ByteCount `json:"pacing_rate"`
+	// ECN-CE marks reported by the peer since the previous StateMsg
+	EcnCe uint64 `json:"ecn_ce"`
+	// time since the previous StateMsg in microseconds
+	Interval int64 `json:"interval"`
+	// see Reward
+	Reward float64 `json:"reward"`
+	FIN    bool    `json:"FIN,omitempty"`
 }
+
+// ActionMsg is the response of the agent to a StateMsg, see ActionSpace.
 type ActionMsg struct {
-	Seq    int `json:"seq"`
+	Seq int `json:"seq"`
+	// index of the action in discrete and multiplicative action spaces
 	Action int `json:"action"`
+	// value of the action in continuous and pacing action spaces
+	Value float64 `json:"value,omitempty"`
 }
+
+// listenRetryInterval is the time between attempts to listen for actions after the transport failed.
+const listenRetryInterval = 100 * time.Millisecond
+
+// RegistryChannel announces new and closed connections, so one agent can drive many connections.
+const RegistryChannel = "/registry"
+
+const (
+	RegistryEventNew    = "new"
+	RegistryEventClosed = "closed"
+)
+
+// RegistryMsg is published on the RegistryChannel.
+type RegistryMsg struct {
+	Event         string `json:"event"`
+	ConnectionID  string `json:"connection_id"`
+	StateChannel  string `json:"state_channel"`
+	ActionChannel string `json:"action_channel"`
+}
+
 type QuicMqManager struct {
-	redis      *RedisManager
-	pubChannel string
-	subChannel string
-	actionCh   chan *ActionMsg
-	seq        int
+	transport    Transport
+	connectionID string
+	pubChannel   string
+	subChannel   string
+	actionCh     chan *ActionMsg
+	seq          int
 }
 
-func NewQuicMqManager(redis *RedisManager, connectionID string) *QuicMqManager {
+func NewQuicMqManager(transport Transport, connectionID string) *QuicMqManager {
 	q := &QuicMqManager{
-		redis:      redis,
-		pubChannel: fmt.Sprintf("/%s/state", connectionID),
-		subChannel: fmt.Sprintf("/%s/action", connectionID),
-		actionCh:   make(chan *ActionMsg, 1),
-		seq:        rand.Intn(100000),
+		transport:    transport,
+		connectionID: connectionID,
+		pubChannel:   fmt.Sprintf("/%s/state", connectionID),
+		subChannel:   fmt.Sprintf("/%s/action", connectionID),
+		actionCh:     make(chan *ActionMsg, 1),
+		seq:          rand.Intn(100000),
 	}
 	return q
 }
 
+// PublishRegistry announces the connection and its channels on the RegistryChannel.
+func (q *QuicMqManager) PublishRegistry(event string) error {
+	body, err := json.Marshal(&RegistryMsg{
+		Event:         event,
+		ConnectionID:  q.connectionID,
+		StateChannel:  q.pubChannel,
+		ActionChannel: q.subChannel,
+	})
+	if err != nil {
+		return err
+	}
+	return q.transport.Publish(RegistryChannel, string(body))
+}
+
 // run in go routine
+// If the transport closes the channel, e.g. because the connection to the agent was lost,
+// it listens again and announces the connection to the agent again.
 func (q *QuicMqManager) ListenAction(ctx context.Context) {
-	sub := q.redis.Subscribe(q.subChannel)
-	ch := sub.Channel()
-	defer sub.Close()
-	for {
-		select {
-		case <-ctx.Done():
-			close(q.actionCh)
-			return
-		case msg := <-ch:
+	defer close(q.actionCh)
+	for reconnect := false; ; reconnect = true {
+		ch, err := q.transport.Listen(ctx, q.subChannel)
+		if err != nil {
+			fmt.Println(err)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(listenRetryInterval):
+				continue
+			}
+		}
+		if reconnect {
+			err = q.PublishRegistry(RegistryEventNew)
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+		for msg := range ch {
 			actionMsg, err := q.readAction(msg)
 			if err != nil {
 				fmt.Println(err)
@@ -57,9 +131,13 @@ func (q *QuicMqManager) ListenAction(ctx context.Context) {
 			q.sendAction(actionMsg)
 			fmt.Println("quic: save action", actionMsg)
 		}
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("rl: %s closed, listen again\n", q.subChannel)
 	}
-
 }
+
 func (q *QuicMqManager) sendAction(a *ActionMsg) {
 	if a.Seq < q.seq {
 		fmt.Printf("action seq %d smaller than state seq %d ,ignore action\n", a.Seq, q.seq)
@@ -78,7 +156,7 @@ func (q *QuicMqManager) PublishState(msg *StateMsg) error {
 	if err != nil {
 		return err
 	}
-	err = q.redis.Publish(q.pubChannel, string(body))
+	err = q.transport.Publish(q.pubChannel, string(body))
 	if err != nil {
 		return err
 	}
@@ -89,19 +167,22 @@ func (q *QuicMqManager) GetActionCh() chan *ActionMsg {
 }
 
 // change to QuicMqManager's actionMsg
-func (q *QuicMqManager) readAction(m *redis.Message) (*ActionMsg, error) {
+func (q *QuicMqManager) readAction(m string) (*ActionMsg, error) {
 	msg := ActionMsg{}
-	err := json.Unmarshal([]byte(m.Payload), &msg)
+	err := json.Unmarshal([]byte(m), &msg)
 	if err != nil {
 		return nil, err
 	}
 	return &msg, nil
 }
 
+// RedisManager is a Transport using Redis pub/sub.
 type RedisManager struct {
 	client *redis.Client
 }
 
+var _ Transport = &RedisManager{}
+
 func NewRedisManager(host, port, password string) (*RedisManager, error) {
 	redisAddr := fmt.Sprintf("%s:%s", host, port)
 	client := redis.NewClient(&redis.Options{
@@ -134,6 +215,39 @@ func (r *RedisManager) Subscribe(channels ...string) *redis.PubSub {
 	return pubsub
 }
 
+func (r *RedisManager) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	sub := r.client.Subscribe(ctx, channel)
+	// wait for the subscription, so no message published afterwards is missed
+	_, err := sub.Receive(ctx)
+	if err != nil {
+		_ = sub.Close()
+		return nil, err
+	}
+	ch := make(chan string)
+	go func() {
+		defer close(ch)
+		defer sub.Close()
+		messages := sub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg := <-messages:
+				select {
+				case ch <- msg.Payload:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return ch, nil
+}
+
+func (r *RedisManager) Close() error {
+	return r.client.Close()
+}
+
 // func main() {
 // 	manager, err := NewRedisManager("localhost", "6379", "")
 // 	if err != nil {
@@ -0,0 +1,263 @@
+package rl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+)
+
+const (
+	// PolicyTable looks up the action of the bins of the features.
+	PolicyTable = "table"
+	// PolicyLinear evaluates weights * features + bias.
+	PolicyLinear = "linear"
+)
+
+// Policy chooses the action of a state, it is used by a single connection.
+// A nil action leaves the cwnd unchanged.
+type Policy interface {
+	Action(state *StateMsg) (*ActionMsg, error)
+}
+
+// PolicyFile is a frozen policy exported by the training code, e.g.
+//
+//	{"type":"table","features":["rtt"],"bins":[[20000,50000]],"actions":[{"action":4},{"action":2},{"action":0}]}
+//	{"type":"linear","features":["rtt","delivery_rate"],"scale":[1e-3,1e-6],"weights":[[-1,1],[1,-1]],"bias":[0,0]}
+//
+// Features are the JSON fields of the StateMsg.
+type PolicyFile struct {
+	Type     string   `json:"type"`
+	Features []string `json:"features"`
+	// table: upper bounds of the bins per feature, a feature with n bounds has n + 1 bins.
+	Bins [][]float64 `json:"bins,omitempty"`
+	// table: actions of all bin combinations, in row-major order of the features
+	Actions []ActionMsg `json:"actions,omitempty"`
+	// linear: features are multiplied by scale before the weights are applied, 1 if omitted
+	Scale []float64 `json:"scale,omitempty"`
+	// linear: a single row sets ActionMsg.Value, multiple rows select the ActionMsg.Action of the largest output
+	Weights [][]float64 `json:"weights,omitempty"`
+	Bias    []float64   `json:"bias,omitempty"`
+}
+
+// LoadPolicy reads and validates a PolicyFile.
+// The returned function creates the policy of a new connection.
+func LoadPolicy(path string) (func() Policy, error) {
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	p := &PolicyFile{}
+	err = json.Unmarshal(body, p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse policy %s: %w", path, err)
+	}
+	err = p.validate()
+	if err != nil {
+		return nil, fmt.Errorf("invalid policy %s: %w", path, err)
+	}
+	// frozen policies are stateless
+	return func() Policy { return p }, nil
+}
+
+func (p *PolicyFile) validate() error {
+	if len(p.Features) == 0 {
+		return fmt.Errorf("no features")
+	}
+	known := stateFeatures(&StateMsg{})
+	for _, f := range p.Features {
+		if _, ok := known[f]; !ok {
+			return fmt.Errorf("unknown feature: %s", f)
+		}
+	}
+	switch p.Type {
+	case PolicyTable:
+		if len(p.Bins) != len(p.Features) {
+			return fmt.Errorf("%d bins for %d features", len(p.Bins), len(p.Features))
+		}
+		combinations := 1
+		for i, bins := range p.Bins {
+			if !sort.Float64sAreSorted(bins) {
+				return fmt.Errorf("bins of %s are not sorted", p.Features[i])
+			}
+			combinations *= len(bins) + 1
+		}
+		if len(p.Actions) != combinations {
+			return fmt.Errorf("%d actions for %d bin combinations", len(p.Actions), combinations)
+		}
+	case PolicyLinear:
+		if p.Scale != nil && len(p.Scale) != len(p.Features) {
+			return fmt.Errorf("%d scales for %d features", len(p.Scale), len(p.Features))
+		}
+		if len(p.Weights) == 0 || len(p.Bias) != len(p.Weights) {
+			return fmt.Errorf("%d weight rows and %d biases", len(p.Weights), len(p.Bias))
+		}
+		for _, row := range p.Weights {
+			if len(row) != len(p.Features) {
+				return fmt.Errorf("%d weights for %d features", len(row), len(p.Features))
+			}
+		}
+	default:
+		return fmt.Errorf("invalid policy type: %s", p.Type)
+	}
+	return nil
+}
+
+func (p *PolicyFile) Action(state *StateMsg) (*ActionMsg, error) {
+	features := stateFeatures(state)
+	var action ActionMsg
+	switch p.Type {
+	case PolicyTable:
+		index := 0
+		for i, f := range p.Features {
+			// index of the first bin whose upper bound is not exceeded
+			bin := sort.SearchFloat64s(p.Bins[i], features[f])
+			index = index*(len(p.Bins[i])+1) + bin
+		}
+		action = p.Actions[index]
+	case PolicyLinear:
+		best := 0
+		var bestOutput float64
+		for row, weights := range p.Weights {
+			output := p.Bias[row]
+			for i, f := range p.Features {
+				x := features[f]
+				if p.Scale != nil {
+					x *= p.Scale[i]
+				}
+				output += weights[i] * x
+			}
+			if row == 0 || output > bestOutput {
+				best, bestOutput = row, output
+			}
+		}
+		if len(p.Weights) == 1 {
+			action.Value = bestOutput
+		} else {
+			action.Action = best
+		}
+	}
+	action.Seq = state.Seq
+	return &action, nil
+}
+
+// stateFeatures maps the numeric JSON fields of the state to their values.
+func stateFeatures(state *StateMsg) map[string]float64 {
+	body, _ := json.Marshal(state)
+	fields := map[string]any{}
+	_ = json.Unmarshal(body, &fields)
+	features := make(map[string]float64, len(fields))
+	for name, value := range fields {
+		if v, ok := value.(float64); ok {
+			features[name] = v
+		}
+	}
+	return features
+}
+
+// replayPolicy returns the recorded actions in order, regardless of the state.
+type replayPolicy struct {
+	actions []*ActionMsg
+	next    int
+}
+
+// LoadReplay reads the actions of a connection from an episode log, see EpisodeLog.
+// If connectionID is empty, the first connection of the log is replayed.
+// Every connection replays the actions from the start, after the last action the cwnd is kept.
+func LoadReplay(path string, connectionID string) (func() Policy, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var actions []*ActionMsg
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		record := EpisodeRecord{}
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s:%d: %w", path, line, err)
+		}
+		if connectionID == "" {
+			connectionID = record.ConnectionID
+		}
+		if record.ConnectionID != connectionID {
+			continue
+		}
+		// steps without action are replayed as well, to keep the actions aligned with the states
+		var action *ActionMsg
+		if record.Action != nil {
+			action = &ActionMsg{Action: *record.Action}
+			if record.ActionValue != nil {
+				action.Value = *record.ActionValue
+			}
+		}
+		actions = append(actions, action)
+	}
+	if err = scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(actions) == 0 {
+		return nil, fmt.Errorf("no steps of connection %q in %s", connectionID, path)
+	}
+	return func() Policy { return &replayPolicy{actions: actions} }, nil
+}
+
+func (r *replayPolicy) Action(state *StateMsg) (*ActionMsg, error) {
+	if r.next >= len(r.actions) {
+		return nil, nil
+	}
+	recorded := r.actions[r.next]
+	r.next++
+	if recorded == nil {
+		return nil, nil
+	}
+	action := *recorded
+	action.Seq = state.Seq
+	return &action, nil
+}
+
+// ServePolicy answers the states of the connection with the actions of the policy until ctx is done,
+// so the rl cc runs without an external agent.
+// The state channel is subscribed before ServePolicy returns, so no state of a sender created afterwards is missed.
+func ServePolicy(ctx context.Context, transport Transport, connectionID string, policy Policy) error {
+	q := NewQuicMqManager(transport, connectionID)
+	states, err := transport.Listen(ctx, q.pubChannel)
+	if err != nil {
+		return err
+	}
+	go func() {
+		for msg := range states {
+			state := StateMsg{}
+			err := json.Unmarshal([]byte(msg), &state)
+			if err != nil {
+				fmt.Println("rl: invalid state:", err)
+				continue
+			}
+			if state.FIN {
+				return
+			}
+			action, err := policy.Action(&state)
+			if err != nil {
+				fmt.Println("rl: policy failed:", err)
+				continue
+			}
+			if action == nil {
+				continue
+			}
+			body, err := json.Marshal(action)
+			if err != nil {
+				fmt.Println(err)
+				continue
+			}
+			err = transport.Publish(q.subChannel, string(body))
+			if err != nil {
+				fmt.Println(err)
+			}
+		}
+	}()
+	return nil
+}
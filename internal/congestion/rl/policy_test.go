@@ -0,0 +1,76 @@
+package rl
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func loadTestPolicy(t *testing.T, policy string) (Policy, error) {
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err := os.WriteFile(path, []byte(policy), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	newPolicy, err := LoadPolicy(path)
+	if err != nil {
+		return nil, err
+	}
+	return newPolicy(), nil
+}
+
+func TestPolicyFile_Table(t *testing.T) {
+	policy, err := loadTestPolicy(t, `{"type":"table","features":["rtt","lost_packets"],"bins":[[20000,50000],[0]],
+		"actions":[{"action":4},{"action":3},{"action":2},{"action":1},{"action":0},{"action":0}]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range []struct {
+		state  StateMsg
+		action int
+	}{
+		{StateMsg{Rtt: 10000}, 4},
+		{StateMsg{Rtt: 20000, LostPackets: 1}, 3},
+		{StateMsg{Rtt: 30000}, 2},
+		{StateMsg{Rtt: 30000, LostPackets: 2}, 1},
+		{StateMsg{Rtt: 60000}, 0},
+	} {
+		c.state.Seq = 7
+		action, err := policy.Action(&c.state)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if action.Action != c.action || action.Seq != 7 {
+			t.Errorf("state %+v: got %+v, expected action %d", c.state, action, c.action)
+		}
+	}
+}
+
+func TestPolicyFile_Linear(t *testing.T) {
+	policy, err := loadTestPolicy(t, `{"type":"linear","features":["rtt","delivery_rate"],"scale":[1e-3,1e-6],"weights":[[-1,1],[1,-1]],"bias":[0,0]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	action, _ := policy.Action(&StateMsg{Rtt: 10000, DeliveryRate: 20e6})
+	if action.Action != 0 {
+		t.Errorf("got action %d, expected 0", action.Action)
+	}
+	action, _ = policy.Action(&StateMsg{Rtt: 30000, DeliveryRate: 20e6})
+	if action.Action != 1 {
+		t.Errorf("got action %d, expected 1", action.Action)
+	}
+
+	policy, err = loadTestPolicy(t, `{"type":"linear","features":["rtt"],"weights":[[0.5]],"bias":[1]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	action, _ = policy.Action(&StateMsg{Rtt: 4})
+	if action.Value != 3 {
+		t.Errorf("got value %v, expected 3", action.Value)
+	}
+
+	_, err = loadTestPolicy(t, `{"type":"linear","features":["unknown"],"weights":[[1]],"bias":[0]}`)
+	if err == nil {
+		t.Error("expected an error for an unknown feature")
+	}
+}
@@ -0,0 +1,51 @@
+package rl
+
+import "fmt"
+
+const (
+	DefaultRewardThroughputWeight = 1
+	DefaultRewardDelayWeight      = 0.1
+	DefaultRewardLossWeight       = 100
+)
+
+// Reward weighs the observations of a step:
+// Throughput * delivery rate in Mbit/s - Delay * queuing delay (RTT - min RTT) in ms - Loss * loss rate in [0, 1].
+type Reward struct {
+	Throughput float64
+	Delay      float64
+	Loss       float64
+}
+
+// NewReward validates the weights, which must not be negative.
+func NewReward(throughput, delay, loss float64) (*Reward, error) {
+	if throughput < 0 || delay < 0 || loss < 0 {
+		return nil, fmt.Errorf("reward weights must not be negative: %v, %v, %v", throughput, delay, loss)
+	}
+	return &Reward{
+		Throughput: throughput,
+		Delay:      delay,
+		Loss:       loss,
+	}, nil
+}
+
+func DefaultReward() *Reward {
+	return &Reward{
+		Throughput: DefaultRewardThroughputWeight,
+		Delay:      DefaultRewardDelayWeight,
+		Loss:       DefaultRewardLossWeight,
+	}
+}
+
+// compute the reward of the observation.
+func (r *Reward) compute(msg *StateMsg) float64 {
+	throughput := float64(msg.DeliveryRate) * 8 / 1e6
+	var queuingDelay float64
+	if msg.Rtt > msg.MinRtt {
+		queuingDelay = float64(msg.Rtt-msg.MinRtt) / 1e3
+	}
+	var lossRate float64
+	if packets := msg.AckedPackets + msg.LostPackets; packets > 0 {
+		lossRate = float64(msg.LostPackets) / float64(packets)
+	}
+	return r.Throughput*throughput - r.Delay*queuingDelay - r.Loss*lossRate
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"qperf-go/internal/congestion/common"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -15,15 +16,17 @@ const (
 	debugEnv                = "HYSTERIA_BRUTAL_DEBUG"
 	debugPrintInterval      = 2
 	initialCongestionWindow = 20
-	MQ_CONNECTION_ID        = "test1234"
+	// used for pacing until the first RTT sample
+	defaultInitialRTT = 100 * time.Millisecond
 )
 
 var _ congestion.CongestionControl = &RLSender{}
 
-type RedisConf struct {
-	Host string
-	Port string
+// ECNCounter returns the number of ECN-CE marks reported by the peer.
+type ECNCounter interface {
+	ECNCE() uint64
 }
+
 type RLSender struct {
 	rttStats        congestion.RTTStatsProvider
 	maxDatagramSize congestion.ByteCount
@@ -32,54 +35,96 @@ type RLSender struct {
 	debug                 bool
 	lastAckPrintTimestamp int64
 	mqManager             *QuicMqManager
-	actionMap             map[int]int
+	actionSpace           *ActionSpace
 	cwnd                  congestion.ByteCount
 	ctx                   context.Context
+	ecnCounter            ECNCounter
+
+	// set by the pacing action space, 0 to derive the pacing rate from cwnd and RTT
+	targetPacingRate congestion.ByteCount
+
+	// nil for the asynchronous mode
+	stepConfig *StepConfig
+	stepCh     chan struct{}
+
+	reward *Reward
+	// nil if no episode is recorded
+	episodeLog    *EpisodeLog
+	episodeMutex  sync.Mutex
+	episodeRecord *EpisodeRecord
+
+	// protects cwnd, maxDatagramSize, targetPacingRate and the statistics below,
+	// which are updated by quic-go and the agent and read by the state publisher
+	mutex        sync.Mutex
+	ackedBytes   uint64
+	ackedPackets uint64
+	lostBytes    uint64
+	lostPackets  uint64
+	// ECN-CE marks counted until the previous state
+	lastEcnCe     uint64
+	bytesInFlight congestion.ByteCount
+	lastStateTime time.Time
+	// set while a step waits for its action
+	stepPending  bool
+	lastStepTime time.Time
 }
 
-func NewRLSender(ctx context.Context, redisConf *RedisConf) *RLSender {
+// NewRLSender publishes states and receives actions on the channels of connectionID,
+// which has to be unique for every concurrent connection.
+// The connection is announced on the RegistryChannel until ctx is done.
+// The transport can be shared by many RLSenders.
+// ecnCounter may be nil if ECN-CE marks are not counted.
+// actionSpace may be nil for the DefaultActionSpace.
+// If stepConfig is nil, states are published every second and actions are applied when they arrive.
+// reward may be nil for the DefaultReward.
+// episodeLog may be nil, it can be shared by many RLSenders.
+func NewRLSender(ctx context.Context, transport Transport, connectionID string, ecnCounter ECNCounter, actionSpace *ActionSpace, stepConfig *StepConfig, reward *Reward, episodeLog *EpisodeLog) *RLSender {
+	if actionSpace == nil {
+		actionSpace = DefaultActionSpace()
+	}
+	if reward == nil {
+		reward = DefaultReward()
+	}
 	debug, _ := strconv.ParseBool(os.Getenv(debugEnv))
 	bs := &RLSender{
 		maxDatagramSize: congestion.InitialPacketSizeIPv4,
 		debug:           debug,
 		cwnd:            initialCongestionWindow * congestion.InitialPacketSizeIPv4,
 		ctx:             ctx,
+		ecnCounter:      ecnCounter,
+		actionSpace:     actionSpace,
+		lastStateTime:   time.Now(),
+		stepConfig:      stepConfig,
+		reward:          reward,
+		episodeLog:      episodeLog,
+		stepCh:          make(chan struct{}, 1),
+		lastStepTime:    time.Now(),
 	}
-	bs.pacer = common.NewPacer(func() congestion.ByteCount {
-		return bs.cwnd
-	})
-	if redisConf == nil {
-		panic("Empty redis conf")
-	}
-
-	//init mq
-	r, err := NewRedisManager(redisConf.Host, redisConf.Port, "")
-	if err != nil {
-		fmt.Println("Failed to create Redis manager:", err)
-		panic(err)
-	}
-
-	bs.mqManager = NewQuicMqManager(r, MQ_CONNECTION_ID)
-	bs.actionMap = map[int]int{
-		0: -3,
-		1: -1,
-		2: 0,
-		3: 1,
-		4: 3,
-	}
+	bs.pacer = common.NewPacer(bs.pacingRate)
+	bs.mqManager = NewQuicMqManager(transport, connectionID)
 	// start mq
 	actionCh := bs.mqManager.GetActionCh()
 	// quic listen action
 	go bs.mqManager.ListenAction(bs.ctx)
+	err := bs.mqManager.PublishRegistry(RegistryEventNew)
+	if err != nil {
+		fmt.Println(err)
+	}
+	if stepConfig != nil {
+		go bs.runSteps()
+		return bs
+	}
 	// apply action to cwnd
 	go func() {
 		for action := range actionCh {
 			fmt.Println("quic: apply action", action)
-			bs.cwnd += congestion.ByteCount(bs.actionMap[action.Action]) * bs.maxDatagramSize
-			if bs.cwnd < 0 {
-				bs.cwnd = 10240
+			cwnd, err := bs.applyAction(action)
+			if err != nil {
+				fmt.Println("quic: invalid action:", err)
+				continue
 			}
-			fmt.Println("quic: new cwnd", bs.cwnd)
+			bs.recordAction(action, false)
+			fmt.Println("quic: new cwnd", cwnd)
 		}
 	}()
 	// publish states
@@ -87,27 +132,20 @@ func NewRLSender(ctx context.Context, redisConf *RedisConf) *RLSender {
 		for {
 			select {
 			case <-bs.ctx.Done():
-				fmt.Println(bs.ctx.Err())
-				err = bs.mqManager.PublishState(&StateMsg{
-					FIN: true,
-				})
-				if err != nil {
-					fmt.Println(err)
-				}
+				bs.publishClose()
 				return
 			case <-time.Tick(time.Second):
 				if bs.rttStats == nil {
 					continue
 				}
-				rtt := bs.rttStats.SmoothedRTT()
-				msg := StateMsg{
-					Cwnd: bs.cwnd * bs.maxDatagramSize,
-					Rtt:  rtt.Microseconds(),
-				}
+				now := time.Now()
+				msg := bs.state(now)
 				err = bs.mqManager.PublishState(&msg)
 				if err != nil {
 					fmt.Println(err)
+					continue
 				}
+				bs.recordState(now, &msg)
 				msg_bytes, _ := json.Marshal(msg)
 				fmt.Println("quic: publish state", string(msg_bytes))
 			}
@@ -116,11 +154,94 @@ func NewRLSender(ctx context.Context, redisConf *RedisConf) *RLSender {
 	return bs
 }
 
+// publishClose sends the final state and announces the closed connection.
+func (b *RLSender) publishClose() {
+	fmt.Println(b.ctx.Err())
+	b.flushEpisode()
+	err := b.mqManager.PublishState(&StateMsg{
+		FIN: true,
+	})
+	if err != nil {
+		fmt.Println(err)
+	}
+	err = b.mqManager.PublishRegistry(RegistryEventClosed)
+	if err != nil {
+		fmt.Println(err)
+	}
+}
+
 func (b *RLSender) SetRTTStatsProvider(rttStats congestion.RTTStatsProvider) {
 	b.rttStats = rttStats
 
 }
 
+// state returns the observation since the previous state and resets the statistics.
+func (b *RLSender) state(now time.Time) StateMsg {
+	var ecnCe uint64
+	if b.ecnCounter != nil {
+		ecnCe = b.ecnCounter.ECNCE()
+	}
+	b.mutex.Lock()
+	interval := now.Sub(b.lastStateTime)
+	msg := StateMsg{
+		Cwnd:          b.cwnd,
+		AckedBytes:    b.ackedBytes,
+		AckedPackets:  b.ackedPackets,
+		LostBytes:     b.lostBytes,
+		LostPackets:   b.lostPackets,
+		BytesInFlight: b.bytesInFlight,
+		EcnCe:         ecnCe - b.lastEcnCe,
+		Interval:      interval.Microseconds(),
+	}
+	b.ackedBytes, b.ackedPackets, b.lostBytes, b.lostPackets = 0, 0, 0, 0
+	b.lastEcnCe = ecnCe
+	b.lastStateTime = now
+	b.mutex.Unlock()
+
+	msg.PacingRate = b.pacingRate()
+	if interval > 0 {
+		msg.DeliveryRate = uint64(float64(msg.AckedBytes) / interval.Seconds())
+	}
+	msg.Rtt = b.rttStats.SmoothedRTT().Microseconds()
+	msg.MinRtt = b.rttStats.MinRTT().Microseconds()
+	msg.LatestRtt = b.rttStats.LatestRTT().Microseconds()
+	msg.RttVar = b.rttStats.MeanDeviation().Microseconds()
+	msg.Reward = b.reward.compute(&msg)
+	return msg
+}
+
+// applyAction changes cwnd and pacing rate and returns the new cwnd.
+func (b *RLSender) applyAction(action *ActionMsg) (congestion.ByteCount, error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	cwnd, pacingRate, err := b.actionSpace.apply(action, b.cwnd, b.maxDatagramSize, b.smoothedRTT())
+	if err != nil {
+		return 0, err
+	}
+	b.cwnd = cwnd
+	b.targetPacingRate = pacingRate
+	return cwnd, nil
+}
+
+// smoothedRTT falls back to the initial RTT of quic-go before the first sample.
+func (b *RLSender) smoothedRTT() time.Duration {
+	if b.rttStats == nil || b.rttStats.SmoothedRTT() == 0 {
+		return defaultInitialRTT
+	}
+	return b.rttStats.SmoothedRTT()
+}
+
+// pacingRate in bytes per second.
+// Unless set by the agent, it is slightly higher than cwnd / RTT, so RTT variations don't leave the cwnd unused.
+func (b *RLSender) pacingRate() congestion.ByteCount {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	if b.targetPacingRate != 0 {
+		return b.targetPacingRate
+	}
+	return congestion.ByteCount(float64(b.cwnd)/b.smoothedRTT().Seconds()) * 5 / 4
+}
+
 func (b *RLSender) TimeUntilSend(bytesInFlight congestion.ByteCount) time.Time {
 	return b.pacer.TimeUntilSend()
 }
@@ -134,6 +255,8 @@ func (b *RLSender) CanSend(bytesInFlight congestion.ByteCount) bool {
 }
 
 func (b *RLSender) GetCongestionWindow() congestion.ByteCount {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
 	return b.cwnd
 }
 
@@ -156,11 +279,28 @@ func (b *RLSender) OnCongestionEvent(number congestion.PacketNumber, lostBytes c
 }
 
 func (b *RLSender) OnCongestionEventEx(priorInFlight congestion.ByteCount, eventTime time.Time, ackedPackets []congestion.AckedPacketInfo, lostPackets []congestion.LostPacketInfo) {
-
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.bytesInFlight = priorInFlight
+	for _, p := range ackedPackets {
+		b.ackedBytes += uint64(p.BytesAcked)
+		b.bytesInFlight -= p.BytesAcked
+	}
+	for _, p := range lostPackets {
+		b.lostBytes += uint64(p.BytesLost)
+		b.bytesInFlight -= p.BytesLost
+	}
+	b.ackedPackets += uint64(len(ackedPackets))
+	b.lostPackets += uint64(len(lostPackets))
+	if len(ackedPackets) > 0 {
+		b.maybeStep(eventTime)
+	}
 }
 
 func (b *RLSender) SetMaxDatagramSize(size congestion.ByteCount) {
+	b.mutex.Lock()
 	b.maxDatagramSize = size
+	b.mutex.Unlock()
 	b.pacer.SetMaxDatagramSize(size)
 	if b.debug {
 		b.debugPrint("SetMaxDatagramSize: %d", size)
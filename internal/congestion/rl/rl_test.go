@@ -0,0 +1,32 @@
+package rl
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type fixedECNCounter struct {
+	ce uint64
+}
+
+func (f *fixedECNCounter) ECNCE() uint64 { return f.ce }
+
+func TestRLSender_StateCountsECNCEPerInterval(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ecnCounter := &fixedECNCounter{ce: 5}
+	sender := NewRLSender(ctx, NewChannelTransport(), "conn", ecnCounter, nil, nil, nil, nil)
+	sender.SetRTTStatsProvider(&fixedRTTStats{rtt: 10 * time.Millisecond})
+
+	if state := sender.state(time.Now()); state.EcnCe != 5 {
+		t.Errorf("got %d ECN-CE marks in the first state, expected 5", state.EcnCe)
+	}
+	if state := sender.state(time.Now()); state.EcnCe != 0 {
+		t.Errorf("got %d ECN-CE marks without new marks, expected 0", state.EcnCe)
+	}
+	ecnCounter.ce = 8
+	if state := sender.state(time.Now()); state.EcnCe != 3 {
+		t.Errorf("got %d ECN-CE marks, expected 3", state.EcnCe)
+	}
+}
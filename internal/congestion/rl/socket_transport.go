@@ -0,0 +1,79 @@
+package rl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sync"
+)
+
+// Envelope wraps a message with its channel on stream based transports.
+type Envelope struct {
+	Channel string          `json:"channel"`
+	Message json.RawMessage `json:"message"`
+}
+
+// SocketTransport connects to an agent listening on a Unix-domain or TCP socket.
+// Every Envelope is encoded as a single line of JSON in both directions.
+type SocketTransport struct {
+	conn       net.Conn
+	writeMutex sync.Mutex
+	// dispatches received messages to the listeners
+	received *ChannelTransport
+}
+
+func NewSocketTransport(network, address string) (*SocketTransport, error) {
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	t := &SocketTransport{
+		conn:     conn,
+		received: NewChannelTransport(),
+	}
+	go t.read()
+	return t, nil
+}
+
+// read dispatches the received messages until the socket is closed, then the listeners are closed.
+func (t *SocketTransport) read() {
+	defer t.received.Close()
+	scanner := bufio.NewScanner(t.conn)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		envelope := Envelope{}
+		err := json.Unmarshal(scanner.Bytes(), &envelope)
+		if err != nil {
+			fmt.Println("rl: invalid message:", err)
+			continue
+		}
+		_ = t.received.Publish(envelope.Channel, string(envelope.Message))
+	}
+	if err := scanner.Err(); err != nil {
+		fmt.Println("rl: socket closed:", err)
+	}
+}
+
+func (t *SocketTransport) Publish(channel, message string) error {
+	body, err := json.Marshal(&Envelope{
+		Channel: channel,
+		Message: json.RawMessage(message),
+	})
+	if err != nil {
+		return err
+	}
+	t.writeMutex.Lock()
+	defer t.writeMutex.Unlock()
+	_, err = t.conn.Write(append(body, '\n'))
+	return err
+}
+
+func (t *SocketTransport) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	return t.received.Listen(ctx, channel)
+}
+
+func (t *SocketTransport) Close() error {
+	return t.conn.Close()
+}
@@ -0,0 +1,111 @@
+package rl
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// echoAction answers a message on a state channel with the same message on the action channel.
+func echoAction(envelope Envelope) Envelope {
+	return Envelope{
+		Channel: envelope.Channel[:len(envelope.Channel)-len("/state")] + "/action",
+		Message: envelope.Message,
+	}
+}
+
+// serveSocketAgent answers every line like an agent, after an invalid line the transport has to skip.
+func serveSocketAgent(t *testing.T, listener net.Listener) {
+	conn, err := listener.Accept()
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer conn.Close()
+	_, err = conn.Write([]byte("invalid\n"))
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		envelope := Envelope{}
+		err := json.Unmarshal(scanner.Bytes(), &envelope)
+		if err != nil {
+			t.Errorf("invalid message: %s", scanner.Bytes())
+			return
+		}
+		body, _ := json.Marshal(echoAction(envelope))
+		_, err = conn.Write(append(body, '\n'))
+		if err != nil {
+			return
+		}
+	}
+}
+
+// testRoundTrip publishes a state and waits for the echoed action.
+func testRoundTrip(t *testing.T, transport Transport) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	actions, err := transport.Listen(ctx, "/conn1/action")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for seq := 1; seq <= 3; seq++ {
+		body, _ := json.Marshal(&ActionMsg{Seq: seq, Action: 2})
+		err = transport.Publish("/conn1/state", string(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case message := <-actions:
+			action := ActionMsg{}
+			err = json.Unmarshal([]byte(message), &action)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if action.Seq != seq || action.Action != 2 {
+				t.Fatalf("unexpected action: %+v", action)
+			}
+		case <-ctx.Done():
+			t.Fatalf("no action %d received", seq)
+		}
+	}
+}
+
+func TestSocketTransport_RoundTrip(t *testing.T) {
+	for _, network := range []string{"tcp", "unix"} {
+		t.Run(network, func(t *testing.T) {
+			address := "127.0.0.1:0"
+			if network == "unix" {
+				address = filepath.Join(t.TempDir(), "agent.sock")
+			}
+			listener, err := net.Listen(network, address)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer listener.Close()
+			go serveSocketAgent(t, listener)
+
+			uri := "tcp://" + listener.Addr().String()
+			if network == "unix" {
+				uri = "unix://" + address
+			}
+			transport, err := NewTransport(uri)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer transport.Close()
+			// messages must be valid JSON
+			err = transport.Publish("/conn1/state", "{")
+			if err == nil {
+				t.Fatal("expected an error for invalid JSON")
+			}
+			testRoundTrip(t, transport)
+		})
+	}
+}
@@ -0,0 +1,148 @@
+package rl
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// StepConfig enables the synchronous step mode.
+// Every Interval smoothed RTTs, checked on ACKs, a StateMsg is published and the cwnd is kept
+// until the agent answers with an ActionMsg of the same Seq.
+// If no answer arrives within Timeout, DefaultAction is applied.
+type StepConfig struct {
+	// in multiples of the smoothed RTT
+	Interval float64
+	Timeout  time.Duration
+	// nil keeps the cwnd
+	DefaultAction *ActionMsg
+}
+
+// NewStepConfig returns nil for the asynchronous mode if interval is 0.
+// defaultAction is parsed by ParseAction of the action space, empty keeps the cwnd on timeouts.
+func NewStepConfig(actionSpace *ActionSpace, interval float64, timeout time.Duration, defaultAction string) (*StepConfig, error) {
+	if interval == 0 {
+		return nil, nil
+	}
+	if interval < 0 {
+		return nil, fmt.Errorf("invalid step interval: %v", interval)
+	}
+	if timeout <= 0 {
+		return nil, fmt.Errorf("invalid step timeout: %s", timeout)
+	}
+	s := &StepConfig{
+		Interval: interval,
+		Timeout:  timeout,
+	}
+	if defaultAction != "" {
+		action, err := actionSpace.ParseAction(defaultAction)
+		if err != nil {
+			return nil, fmt.Errorf("invalid default action: %w", err)
+		}
+		s.DefaultAction = action
+	}
+	return s, nil
+}
+
+// ParseAction parses an index for discrete and multiplicative action spaces and a value otherwise.
+func (a *ActionSpace) ParseAction(s string) (*ActionMsg, error) {
+	switch a.Type {
+	case ActionSpaceDiscrete, ActionSpaceMultiplicative:
+		index, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		if index < 0 || index >= len(a.Values) {
+			return nil, fmt.Errorf("action %d out of range [0, %d)", index, len(a.Values))
+		}
+		return &ActionMsg{Action: index}, nil
+	default:
+		value, err := strconv.ParseFloat(s, 64)
+		if err != nil {
+			return nil, err
+		}
+		return &ActionMsg{Value: value}, nil
+	}
+}
+
+// maybeStep triggers the next step if the interval since the previous one has passed.
+// b.mutex has to be held.
+func (b *RLSender) maybeStep(eventTime time.Time) {
+	if b.stepConfig == nil || b.stepPending || b.rttStats == nil {
+		return
+	}
+	interval := time.Duration(b.stepConfig.Interval * float64(b.smoothedRTT()))
+	if eventTime.Sub(b.lastStepTime) < interval {
+		return
+	}
+	b.stepPending = true
+	select {
+	case b.stepCh <- struct{}{}:
+	default:
+	}
+}
+
+// runSteps publishes a state per step and waits for the matching action.
+// Actions of other steps are discarded.
+func (b *RLSender) runSteps() {
+	actionCh := b.mqManager.GetActionCh()
+	var seq int
+	// nil while no step waits for an action
+	var timeout <-chan time.Time
+	for {
+		select {
+		case <-b.ctx.Done():
+			b.publishClose()
+			return
+		case <-b.stepCh:
+			now := time.Now()
+			msg := b.state(now)
+			err := b.mqManager.PublishState(&msg)
+			if err != nil {
+				fmt.Println(err)
+				b.finishStep(b.stepConfig.DefaultAction, true)
+				continue
+			}
+			b.recordState(now, &msg)
+			msgBytes, _ := json.Marshal(msg)
+			fmt.Println("quic: publish state", string(msgBytes))
+			seq = msg.Seq
+			timeout = time.After(b.stepConfig.Timeout)
+		case action, ok := <-actionCh:
+			if !ok {
+				actionCh = nil
+				continue
+			}
+			if timeout == nil || action.Seq != seq {
+				fmt.Printf("quic: action seq %d does not match step seq %d, ignore action\n", action.Seq, seq)
+				continue
+			}
+			fmt.Println("quic: apply action", action)
+			timeout = nil
+			b.finishStep(action, false)
+		case <-timeout:
+			fmt.Printf("quic: no action for step seq %d, apply default action %v\n", seq, b.stepConfig.DefaultAction)
+			timeout = nil
+			b.finishStep(b.stepConfig.DefaultAction, true)
+		}
+	}
+}
+
+// finishStep applies the action, nil keeps the cwnd, and starts the next interval.
+// timeout is set for the default action.
+func (b *RLSender) finishStep(action *ActionMsg, timeout bool) {
+	if action != nil {
+		cwnd, err := b.applyAction(action)
+		if err != nil {
+			fmt.Println("quic: invalid action:", err)
+		} else {
+			b.recordAction(action, timeout)
+			fmt.Println("quic: new cwnd", cwnd)
+		}
+	}
+	b.mutex.Lock()
+	b.stepPending = false
+	b.lastStepTime = time.Now()
+	b.mutex.Unlock()
+}
@@ -0,0 +1,136 @@
+package rl
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/apernet/quic-go/congestion"
+)
+
+type fixedRTTStats struct {
+	congestion.RTTStatsProvider
+	rtt time.Duration
+}
+
+func (f *fixedRTTStats) MinRTT() time.Duration        { return f.rtt }
+func (f *fixedRTTStats) LatestRTT() time.Duration     { return f.rtt }
+func (f *fixedRTTStats) SmoothedRTT() time.Duration   { return f.rtt }
+func (f *fixedRTTStats) MeanDeviation() time.Duration { return 0 }
+
+// waitForCwnd polls the cwnd, as actions are applied asynchronously.
+func waitForCwnd(t *testing.T, sender *RLSender, cwnd congestion.ByteCount) {
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		if sender.GetCongestionWindow() == cwnd {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("cwnd is %d, expected %d", sender.GetCongestionWindow(), cwnd)
+}
+
+func TestRLSender_Step(t *testing.T) {
+	transport := NewChannelTransport()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	states, err := transport.Listen(ctx, "/conn/state")
+	if err != nil {
+		t.Fatal(err)
+	}
+	actionSpace := DefaultActionSpace()
+	stepConfig, err := NewStepConfig(actionSpace, 1, 50*time.Millisecond, "0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	episodeFile := filepath.Join(t.TempDir(), "episode.jsonl")
+	episodeLog, err := NewEpisodeLog(episodeFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer episodeLog.Close()
+	sender := NewRLSender(ctx, transport, "conn", nil, actionSpace, stepConfig, nil, episodeLog)
+	sender.SetRTTStatsProvider(&fixedRTTStats{rtt: 10 * time.Millisecond})
+	waitForListener(t, transport, "/conn/action")
+	initialCwnd := sender.GetCongestionWindow()
+	ack := func(eventTime time.Time) {
+		sender.OnCongestionEventEx(1000, eventTime, []congestion.AckedPacketInfo{{BytesAcked: 1000}}, nil)
+	}
+	readState := func() StateMsg {
+		select {
+		case s := <-states:
+			msg := StateMsg{}
+			err := json.Unmarshal([]byte(s), &msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return msg
+		case <-time.After(time.Second):
+			t.Fatal("no state published")
+		}
+		return StateMsg{}
+	}
+
+	// no step before one RTT passed
+	ack(time.Now())
+	ack(time.Now().Add(20 * time.Millisecond))
+	state := readState()
+	if state.Cwnd != initialCwnd || state.AckedPackets != 2 {
+		t.Fatalf("unexpected state %+v", state)
+	}
+	// no new step while waiting for the action
+	ack(time.Now().Add(time.Second))
+	select {
+	case s := <-states:
+		t.Fatalf("unexpected state while waiting for the action: %s", s)
+	case <-time.After(10 * time.Millisecond):
+	}
+	// only the action of the step is applied
+	err = transport.Publish("/conn/action", fmt.Sprintf(`{"seq":%d,"action":0}`, state.Seq+1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = transport.Publish("/conn/action", fmt.Sprintf(`{"seq":%d,"action":4}`, state.Seq))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForCwnd(t, sender, initialCwnd+3*congestion.InitialPacketSizeIPv4)
+
+	// the default action is applied on timeouts
+	ack(time.Now().Add(time.Second))
+	readState()
+	waitForCwnd(t, sender, initialCwnd)
+
+	// the records are written when the next state is published or the connection is closed
+	cancel()
+	var records []EpisodeRecord
+	deadline := time.Now().Add(time.Second)
+	for len(records) < 2 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+		body, err := os.ReadFile(episodeFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		records = nil
+		for _, line := range strings.Split(strings.TrimSpace(string(body)), "\n") {
+			record := EpisodeRecord{}
+			if json.Unmarshal([]byte(line), &record) == nil {
+				records = append(records, record)
+			}
+		}
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 episode records, got %d", len(records))
+	}
+	if records[0].ConnectionID != "conn" || records[0].Seq != state.Seq || records[0].Action == nil || *records[0].Action != 4 || records[0].ActionTimeout {
+		t.Fatalf("unexpected first record %+v", records[0])
+	}
+	if records[1].Action == nil || *records[1].Action != 0 || !records[1].ActionTimeout {
+		t.Fatalf("unexpected second record %+v", records[1])
+	}
+}
@@ -0,0 +1,47 @@
+package rl
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// Transport carries the messages between the RLSenders and the agent.
+// Messages are JSON encoded and published on named channels:
+// the RegistryChannel and the state and action channel of every connection.
+type Transport interface {
+	// Publish sends the message on the channel.
+	Publish(channel, message string) error
+	// Listen delivers the messages published on the channel until ctx is done.
+	Listen(ctx context.Context, channel string) (<-chan string, error)
+	Close() error
+}
+
+// NewTransport creates a transport from its URI:
+// redis://host:port for Redis pub/sub,
+// unix:///path/to/socket or tcp://host:port for newline-delimited JSON over a socket,
+// grpc://host:port for gRPC.
+func NewTransport(uri string) (Transport, error) {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "redis":
+		host, port, err := net.SplitHostPort(u.Host)
+		if err != nil {
+			return nil, err
+		}
+		password, _ := u.User.Password()
+		return NewRedisManager(host, port, password)
+	case "unix":
+		return NewSocketTransport("unix", u.Path)
+	case "tcp":
+		return NewSocketTransport("tcp", u.Host)
+	case "grpc":
+		return NewGRPCTransport(u.Host)
+	default:
+		return nil, fmt.Errorf("unsupported rl transport: %s", uri)
+	}
+}
@@ -4,19 +4,34 @@ import (
 	"github.com/apernet/quic-go"
 	bbr2 "qperf-go/internal/congestion/bbr"
 	"qperf-go/internal/congestion/brutal"
+	"qperf-go/internal/congestion/cubic"
 	"qperf-go/internal/congestion/rl"
 )
 
-func UseBBR(conn quic.Connection) {
-	conn.SetCongestionControl(bbr2.NewBbrSender(
+func UseBBR(conn quic.Connection) bbr2.StateProvider {
+	sender := bbr2.NewBbrSender(
 		bbr2.DefaultClock{},
 		bbr2.GetInitialPacketSize(conn.RemoteAddr()),
+	)
+	conn.SetCongestionControl(sender)
+	return sender
+}
+
+// UseCubic replaces the congestion control with our own Cubic sender, or a Reno sender if reno is set.
+func UseCubic(conn quic.Connection, reno bool) {
+	conn.SetCongestionControl(cubic.NewCubicSender(
+		cubic.DefaultClock{},
+		bbr2.GetInitialPacketSize(conn.RemoteAddr()),
+		reno,
 	))
 }
 
-func UseBrutal(conn quic.Connection, tx uint64) {
-	conn.SetCongestionControl(brutal.NewBrutalSender(tx))
+func UseBrutal(conn quic.Connection, config *brutal.Config) {
+	conn.SetCongestionControl(brutal.NewBrutalSender(config))
 }
-func UseRL(conn quic.Connection, redisConf *rl.RedisConf) {
-	conn.SetCongestionControl(rl.NewRLSender(conn.Context(), redisConf))
+
+// UseRL replaces the congestion control with the RL sender, connectionID selects its channels on the transport.
+// ecnCounter and actionSpace may be nil.
+func UseRL(conn quic.Connection, transport rl.Transport, connectionID string, ecnCounter rl.ECNCounter, actionSpace *rl.ActionSpace, stepConfig *rl.StepConfig, reward *rl.Reward, episodeLog *rl.EpisodeLog) {
+	conn.SetCongestionControl(rl.NewRLSender(conn.Context(), transport, connectionID, ecnCounter, actionSpace, stepConfig, reward, episodeLog))
 }
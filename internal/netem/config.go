@@ -0,0 +1,251 @@
+package netem
+
+import (
+	"fmt"
+	"qperf-go/common"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config describes the emulated link in the sending direction of a PacketConn.
+// Zero values disable the respective impairment.
+type Config struct {
+	// bottleneck bandwidth in bytes per second, 0 for no bandwidth limit and no queue
+	Bandwidth uint64
+	// varying bottleneck bandwidth, replaces Bandwidth
+	Trace *Trace
+	// one-way propagation delay
+	Delay time.Duration
+	// varying propagation delay, replaces Delay
+	DelayTrace *DelayTrace
+	// the delay of each packet varies uniformly by up to Jitter, which reorders packets
+	Jitter time.Duration
+	// probability of a random loss
+	Loss float64
+	// burst loss, applied in addition to Loss
+	GilbertElliott *GilbertElliott
+	// probability that a packet skips the propagation delay and overtakes earlier packets
+	Reorder float64
+	// probability that a packet is sent twice
+	Duplicate float64
+	// maximum queue size of the bottleneck in bytes, 0 for an unlimited queue
+	QueueSize uint64
+	// active queue management, drop-tail if nil
+	RED *RED
+	// seed of the random number generator, so runs are reproducible
+	Seed int64
+}
+
+// GilbertElliott is a two state Markov chain of burst losses.
+type GilbertElliott struct {
+	// transition probability per packet from the good to the bad state
+	P float64
+	// transition probability per packet from the bad to the good state
+	R float64
+	// loss probabilities in the good and bad state
+	LossGood float64
+	LossBad  float64
+}
+
+// RED is random early detection of the bottleneck queue.
+type RED struct {
+	// average queue sizes in bytes, between which the drop probability rises from 0 to MaxP
+	MinThreshold uint64
+	MaxThreshold uint64
+	MaxP         float64
+	// weight of the current queue size in the moving average
+	Weight float64
+}
+
+// defaultREDWeight is the queue weight recommended for RED.
+const defaultREDWeight = 0.002
+
+// ParseConfig parses a comma separated list of key=value pairs, e.g.
+//
+//	bw=10Mbps,delay=20ms,jitter=2ms,loss=0.01,ge=0.01:0.3,reorder=0.01,dup=0.001,queue=64KiB,red=20KiB:60KiB:0.1,seed=1
+//	trace=traces/LTE.down,delay-trace=traces/LTE.delay,queue=150KiB
+//
+// trace is a Mahimahi trace, see LoadTrace, delay-trace a delay trace, see LoadDelayTrace.
+// ge is p:r[:loss_good:loss_bad] with the defaults 0 and 1 for the loss probabilities,
+// red is min:max:max_p[:weight].
+// An empty string returns nil.
+func ParseConfig(s string) (*Config, error) {
+	if s == "" {
+		return nil, nil
+	}
+	c := &Config{}
+	for _, option := range strings.Split(s, ",") {
+		key, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid netem option: %s", option)
+		}
+		var err error
+		switch key {
+		case "bw":
+			c.Bandwidth, err = common.ParseBandwidth(value)
+		case "trace":
+			c.Trace, err = LoadTrace(value)
+		case "delay-trace":
+			c.DelayTrace, err = LoadDelayTrace(value)
+		case "delay":
+			c.Delay, err = time.ParseDuration(value)
+		case "jitter":
+			c.Jitter, err = time.ParseDuration(value)
+		case "loss":
+			c.Loss, err = parseProbability(value)
+		case "ge":
+			c.GilbertElliott, err = parseGilbertElliott(value)
+		case "reorder":
+			c.Reorder, err = parseProbability(value)
+		case "dup":
+			c.Duplicate, err = parseProbability(value)
+		case "queue":
+			c.QueueSize, err = common.ParseByteCountWithUnit(value)
+		case "red":
+			c.RED, err = parseRED(value)
+		case "seed":
+			c.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return nil, fmt.Errorf("unknown netem option: %s", key)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid netem option %s: %w", key, err)
+		}
+	}
+	if c.Jitter > c.Delay && c.DelayTrace == nil {
+		return nil, fmt.Errorf("jitter %s exceeds the delay %s", c.Jitter, c.Delay)
+	}
+	if c.Bandwidth != 0 && c.Trace != nil {
+		return nil, fmt.Errorf("bw and trace are mutually exclusive")
+	}
+	if c.Delay != 0 && c.DelayTrace != nil {
+		return nil, fmt.Errorf("delay and delay-trace are mutually exclusive")
+	}
+	if c.RED != nil && !c.hasBottleneck() {
+		return nil, fmt.Errorf("red requires a bandwidth limit")
+	}
+	return c, nil
+}
+
+// hasBottleneck is true if packets are queued.
+func (c *Config) hasBottleneck() bool {
+	return c.Bandwidth > 0 || c.Trace != nil
+}
+
+func parseProbability(s string) (float64, error) {
+	p, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+	if p < 0 || p > 1 {
+		return 0, fmt.Errorf("probability %v not in [0, 1]", p)
+	}
+	return p, nil
+}
+
+func parseProbabilities(s string, min, max int) ([]float64, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < min || len(parts) > max {
+		return nil, fmt.Errorf("expected %d to %d values", min, max)
+	}
+	values := make([]float64, len(parts))
+	for i, part := range parts {
+		var err error
+		values[i], err = parseProbability(part)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+func parseGilbertElliott(s string) (*GilbertElliott, error) {
+	values, err := parseProbabilities(s, 2, 4)
+	if err != nil {
+		return nil, err
+	}
+	ge := &GilbertElliott{P: values[0], R: values[1], LossBad: 1}
+	if len(values) > 2 {
+		ge.LossGood = values[2]
+	}
+	if len(values) > 3 {
+		ge.LossBad = values[3]
+	}
+	return ge, nil
+}
+
+func parseRED(s string) (*RED, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) < 3 || len(parts) > 4 {
+		return nil, fmt.Errorf("expected min:max:max_p[:weight]")
+	}
+	minThreshold, err := common.ParseByteCountWithUnit(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	maxThreshold, err := common.ParseByteCountWithUnit(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	if maxThreshold <= minThreshold {
+		return nil, fmt.Errorf("max threshold %d not above min threshold %d", maxThreshold, minThreshold)
+	}
+	probabilities, err := parseProbabilities(strings.Join(parts[2:], ":"), 1, 2)
+	if err != nil {
+		return nil, err
+	}
+	red := &RED{
+		MinThreshold: minThreshold,
+		MaxThreshold: maxThreshold,
+		MaxP:         probabilities[0],
+		Weight:       defaultREDWeight,
+	}
+	if len(probabilities) > 1 {
+		red.Weight = probabilities[1]
+	}
+	return red, nil
+}
+
+// String returns the Config in the format of ParseConfig.
+func (c *Config) String() string {
+	var options []string
+	add := func(key string, value any) {
+		options = append(options, fmt.Sprintf("%s=%v", key, value))
+	}
+	if c.Bandwidth != 0 {
+		add("bw", fmt.Sprintf("%dB/s", c.Bandwidth))
+	}
+	if c.Trace != nil {
+		add("trace", c.Trace.Path)
+	}
+	if c.Delay != 0 {
+		add("delay", c.Delay)
+	}
+	if c.DelayTrace != nil {
+		add("delay-trace", c.DelayTrace.Path)
+	}
+	if c.Jitter != 0 {
+		add("jitter", c.Jitter)
+	}
+	if c.Loss != 0 {
+		add("loss", c.Loss)
+	}
+	if ge := c.GilbertElliott; ge != nil {
+		add("ge", fmt.Sprintf("%v:%v:%v:%v", ge.P, ge.R, ge.LossGood, ge.LossBad))
+	}
+	if c.Reorder != 0 {
+		add("reorder", c.Reorder)
+	}
+	if c.Duplicate != 0 {
+		add("dup", c.Duplicate)
+	}
+	if c.QueueSize != 0 {
+		add("queue", c.QueueSize)
+	}
+	if red := c.RED; red != nil {
+		add("red", fmt.Sprintf("%d:%d:%v:%v", red.MinThreshold, red.MaxThreshold, red.MaxP, red.Weight))
+	}
+	add("seed", c.Seed)
+	return strings.Join(options, ",")
+}
@@ -0,0 +1,304 @@
+package netem
+
+import (
+	"container/heap"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// PacketConn emulates the link of its Config for all packets written to it.
+// Received packets are not affected, the peer emulates the other direction.
+type PacketConn struct {
+	net.PacketConn
+	config *Config
+
+	mutex sync.Mutex
+	rand  *rand.Rand
+	// state of the Gilbert-Elliott model
+	bad bool
+	// moving average of the queue size for RED
+	averageQueue float64
+	// packets in the bottleneck queue, ordered by departure
+	queue      []queuedPacket
+	queueBytes int
+	// time at which the bottleneck has sent all queued packets
+	busyUntil time.Time
+	// start of the traces
+	start time.Time
+	// next delivery opportunity of the trace and the bytes left in it
+	traceCursor traceCursor
+	traceCredit int
+	pending     packetHeap
+	sequence    uint64
+	stats       Stats
+
+	wakeup    chan struct{}
+	closed    chan struct{}
+	closeOnce sync.Once
+}
+
+// Stats counts the packets written to a PacketConn.
+type Stats struct {
+	Sent       uint64
+	Lost       uint64
+	Dropped    uint64
+	Duplicated uint64
+}
+
+// NewPacketConn emulates the link on top of conn, closing the PacketConn closes conn.
+func NewPacketConn(conn net.PacketConn, config *Config) *PacketConn {
+	c := &PacketConn{
+		PacketConn:  conn,
+		config:      config,
+		rand:        rand.New(rand.NewSource(config.Seed)),
+		start:       time.Now(),
+		traceCredit: TracePacketSize,
+		wakeup:      make(chan struct{}, 1),
+		closed:      make(chan struct{}),
+	}
+	go c.run()
+	return c
+}
+
+// WriteTo schedules the packet and returns immediately, like a write to a UDP socket.
+func (c *PacketConn) WriteTo(p []byte, addr net.Addr) (int, error) {
+	select {
+	case <-c.closed:
+		return 0, net.ErrClosed
+	default:
+	}
+	now := time.Now()
+	c.mutex.Lock()
+	c.stats.Sent++
+	delivery, ok := c.schedule(now, len(p))
+	if ok {
+		c.push(delivery, p, addr)
+		if c.config.Duplicate > 0 && c.rand.Float64() < c.config.Duplicate {
+			c.stats.Duplicated++
+			c.push(delivery, p, addr)
+		}
+	}
+	c.mutex.Unlock()
+	select {
+	case c.wakeup <- struct{}{}:
+	default:
+	}
+	return len(p), nil
+}
+
+// schedule returns the delivery time of the packet, or false if it is lost or dropped.
+func (c *PacketConn) schedule(now time.Time, size int) (time.Time, bool) {
+	if c.lose() {
+		c.stats.Lost++
+		return time.Time{}, false
+	}
+	departure := now
+	if c.config.hasBottleneck() {
+		// remove the packets which left the bottleneck
+		for len(c.queue) > 0 && !c.queue[0].departure.After(now) {
+			c.queueBytes -= c.queue[0].size
+			c.queue = c.queue[1:]
+		}
+		if c.drop(float64(c.queueBytes), size) {
+			c.stats.Dropped++
+			return time.Time{}, false
+		}
+		if c.config.Trace != nil {
+			departure = c.serveTrace(now, size)
+		} else {
+			if c.busyUntil.Before(now) {
+				c.busyUntil = now
+			}
+			c.busyUntil = c.busyUntil.Add(time.Duration(float64(size) / float64(c.config.Bandwidth) * float64(time.Second)))
+			departure = c.busyUntil
+		}
+		c.queue = append(c.queue, queuedPacket{departure: departure, size: size})
+		c.queueBytes += size
+	}
+	if c.config.Reorder > 0 && c.rand.Float64() < c.config.Reorder {
+		return departure, true
+	}
+	delay := c.config.Delay
+	if c.config.DelayTrace != nil {
+		delay = c.config.DelayTrace.at(departure.Sub(c.start))
+	}
+	if c.config.Jitter > 0 {
+		delay += time.Duration((c.rand.Float64()*2 - 1) * float64(c.config.Jitter))
+		delay = max(delay, 0)
+	}
+	return departure.Add(delay), true
+}
+
+// serveTrace returns the delivery opportunity at which the last byte of the packet leaves the bottleneck.
+// Like in Mahimahi, opportunities are lost while the queue is empty.
+func (c *PacketConn) serveTrace(now time.Time, size int) time.Time {
+	trace := c.config.Trace
+	if offset := now.Sub(c.start); trace.time(c.traceCursor) < offset {
+		c.traceCursor = trace.at(offset)
+		c.traceCredit = TracePacketSize
+	}
+	for {
+		if c.traceCredit == 0 {
+			c.traceCursor = trace.next(c.traceCursor)
+			c.traceCredit = TracePacketSize
+		}
+		served := min(size, c.traceCredit)
+		c.traceCredit -= served
+		size -= served
+		if size == 0 {
+			return c.start.Add(trace.time(c.traceCursor))
+		}
+	}
+}
+
+// lose applies the random and burst losses.
+func (c *PacketConn) lose() bool {
+	lost := c.config.Loss > 0 && c.rand.Float64() < c.config.Loss
+	if ge := c.config.GilbertElliott; ge != nil {
+		if c.bad {
+			c.bad = c.rand.Float64() >= ge.R
+		} else {
+			c.bad = c.rand.Float64() < ge.P
+		}
+		lossProbability := ge.LossGood
+		if c.bad {
+			lossProbability = ge.LossBad
+		}
+		if c.rand.Float64() < lossProbability {
+			lost = true
+		}
+	}
+	return lost
+}
+
+// drop applies RED and the queue size to a packet arriving at a queue of the given size in bytes.
+func (c *PacketConn) drop(queue float64, size int) bool {
+	if red := c.config.RED; red != nil {
+		c.averageQueue = (1-red.Weight)*c.averageQueue + red.Weight*queue
+		switch {
+		case c.averageQueue >= float64(red.MaxThreshold):
+			return true
+		case c.averageQueue > float64(red.MinThreshold):
+			p := red.MaxP * (c.averageQueue - float64(red.MinThreshold)) / float64(red.MaxThreshold-red.MinThreshold)
+			if c.rand.Float64() < p {
+				return true
+			}
+		}
+	}
+	return c.config.QueueSize > 0 && queue+float64(size) > float64(c.config.QueueSize)
+}
+
+func (c *PacketConn) push(delivery time.Time, p []byte, addr net.Addr) {
+	c.sequence++
+	heap.Push(&c.pending, &packet{
+		delivery: delivery,
+		sequence: c.sequence,
+		data:     append([]byte(nil), p...),
+		addr:     addr,
+	})
+}
+
+// run writes the packets to the underlying conn at their delivery time.
+func (c *PacketConn) run() {
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	var due []*packet
+	for {
+		c.mutex.Lock()
+		now := time.Now()
+		due = due[:0]
+		for len(c.pending) > 0 && !c.pending[0].delivery.After(now) {
+			due = append(due, heap.Pop(&c.pending).(*packet))
+		}
+		wait := time.Hour
+		if len(c.pending) > 0 {
+			wait = c.pending[0].delivery.Sub(now)
+		}
+		c.mutex.Unlock()
+
+		for _, p := range due {
+			// like a router, the link does not report errors to the sender
+			_, _ = c.PacketConn.WriteTo(p.data, p.addr)
+		}
+
+		if !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		timer.Reset(wait)
+		select {
+		case <-c.closed:
+			return
+		case <-c.wakeup:
+		case <-timer.C:
+		}
+	}
+}
+
+// Stats returns the packet counters.
+func (c *PacketConn) Stats() Stats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.stats
+}
+
+// Close discards the packets which are not delivered yet.
+func (c *PacketConn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+	})
+	return c.PacketConn.Close()
+}
+
+// SetReadBuffer is used by quic-go to increase the receive buffer.
+func (c *PacketConn) SetReadBuffer(bytes int) error {
+	if conn, ok := c.PacketConn.(interface{ SetReadBuffer(int) error }); ok {
+		return conn.SetReadBuffer(bytes)
+	}
+	return nil
+}
+
+// SetWriteBuffer is used by quic-go to increase the send buffer.
+func (c *PacketConn) SetWriteBuffer(bytes int) error {
+	if conn, ok := c.PacketConn.(interface{ SetWriteBuffer(int) error }); ok {
+		return conn.SetWriteBuffer(bytes)
+	}
+	return nil
+}
+
+type queuedPacket struct {
+	departure time.Time
+	size      int
+}
+
+type packet struct {
+	delivery time.Time
+	// keeps the order of packets with the same delivery time
+	sequence uint64
+	data     []byte
+	addr     net.Addr
+}
+
+// packetHeap orders the packets by delivery time.
+type packetHeap []*packet
+
+func (h packetHeap) Len() int { return len(h) }
+func (h packetHeap) Less(i, j int) bool {
+	if h[i].delivery.Equal(h[j].delivery) {
+		return h[i].sequence < h[j].sequence
+	}
+	return h[i].delivery.Before(h[j].delivery)
+}
+func (h packetHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *packetHeap) Push(x any)   { *h = append(*h, x.(*packet)) }
+func (h *packetHeap) Pop() any {
+	old := *h
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	*h = old[:len(old)-1]
+	return p
+}
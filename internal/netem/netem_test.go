@@ -0,0 +1,157 @@
+package netem
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestConns(t *testing.T, config *Config) (*PacketConn, net.PacketConn) {
+	sender, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := NewPacketConn(sender, config)
+	t.Cleanup(func() {
+		_ = conn.Close()
+		_ = receiver.Close()
+	})
+	return conn, receiver
+}
+
+// receive reads packets until no packet arrived within the timeout.
+func receive(t *testing.T, conn net.PacketConn, timeout time.Duration) (packets int, last time.Time) {
+	buf := make([]byte, 2048)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(timeout))
+		_, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			return packets, last
+		}
+		packets++
+		last = time.Now()
+	}
+}
+
+func TestPacketConn_BandwidthDelayQueue(t *testing.T) {
+	// 10 packets of 1000 bytes fit into the queue, each takes 10ms to send
+	conn, receiver := newTestConns(t, &Config{
+		Bandwidth: 100_000,
+		Delay:     20 * time.Millisecond,
+		QueueSize: 10_000,
+	})
+	start := time.Now()
+	for i := 0; i < 20; i++ {
+		_, err := conn.WriteTo(make([]byte, 1000), receiver.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	packets, last := receive(t, receiver, 200*time.Millisecond)
+	if packets != 10 {
+		t.Errorf("received %d packets, expected 10", packets)
+	}
+	if stats := conn.Stats(); stats.Sent != 20 || stats.Dropped != 10 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+	// the last packet leaves the bottleneck after 100ms and arrives after 120ms
+	if elapsed := last.Sub(start); elapsed < 120*time.Millisecond || elapsed > 170*time.Millisecond {
+		t.Errorf("last packet arrived after %s, expected 120ms", elapsed)
+	}
+}
+
+func TestPacketConn_LossIsReproducible(t *testing.T) {
+	config, err := ParseConfig("loss=0.1,ge=0.05:0.5,seed=42")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var lost []uint64
+	for run := 0; run < 2; run++ {
+		conn, receiver := newTestConns(t, config)
+		for i := 0; i < 1000; i++ {
+			_, _ = conn.WriteTo([]byte{1}, receiver.LocalAddr())
+		}
+		lost = append(lost, conn.Stats().Lost)
+	}
+	if lost[0] != lost[1] {
+		t.Errorf("losses differ with the same seed: %v", lost)
+	}
+	// 10% random loss and 1/11 of the packets in the bad state
+	if lost[0] < 120 || lost[0] > 260 {
+		t.Errorf("lost %d of 1000 packets, expected about 180", lost[0])
+	}
+}
+
+func TestParseConfig(t *testing.T) {
+	config, err := ParseConfig("bw=8Mbps,delay=20ms,jitter=2ms,reorder=0.01,dup=0.001,queue=64KiB,red=20KiB:60KiB:0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Bandwidth != 1_000_000 || config.Delay != 20*time.Millisecond || config.QueueSize != 64<<10 ||
+		config.RED == nil || config.RED.MaxThreshold != 60<<10 || config.RED.Weight != defaultREDWeight {
+		t.Errorf("unexpected config %+v", config)
+	}
+	parsed, err := ParseConfig(config.String())
+	if err != nil || parsed.String() != config.String() {
+		t.Errorf("%s does not round trip: %v", config, err)
+	}
+	for _, invalid := range []string{"loss=2", "delay=1ms,jitter=2ms", "red=1:2:0.1", "unknown=1", "bw"} {
+		if _, err := ParseConfig(invalid); err == nil {
+			t.Errorf("expected an error for %s", invalid)
+		}
+	}
+}
+
+func TestPacketConn_Trace(t *testing.T) {
+	dir := t.TempDir()
+	err := os.WriteFile(filepath.Join(dir, "link.trace"), []byte("10\n20\n30\n40\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(filepath.Join(dir, "link.delay"), []byte("# ms delay\n0 5\n50 30\n100 5\n"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := ParseConfig(fmt.Sprintf("trace=%s,delay-trace=%s", filepath.Join(dir, "link.trace"), filepath.Join(dir, "link.delay")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, receiver := newTestConns(t, config)
+	start := conn.start
+	// one packet per opportunity, the trace repeats every 40ms
+	for i := 0; i < 10; i++ {
+		_, err := conn.WriteTo(append([]byte{byte(i)}, make([]byte, TracePacketSize-1)...), receiver.LocalAddr())
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	arrivals := make(map[byte]time.Duration)
+	buf := make([]byte, 2048)
+	for len(arrivals) < 10 {
+		_ = receiver.SetReadDeadline(time.Now().Add(time.Second))
+		_, _, err := receiver.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("received %d packets: %v", len(arrivals), err)
+		}
+		arrivals[buf[0]] = time.Since(start)
+	}
+	for i, expected := range map[byte]time.Duration{
+		// departure at 40ms with 5ms delay
+		3: 45 * time.Millisecond,
+		// departure at 50ms with 30ms delay
+		4: 80 * time.Millisecond,
+		// departure at 100ms with 5ms delay
+		9: 105 * time.Millisecond,
+	} {
+		if arrivals[i] < expected || arrivals[i] > expected+30*time.Millisecond {
+			t.Errorf("packet %d arrived after %s, expected %s", i, arrivals[i], expected)
+		}
+	}
+}
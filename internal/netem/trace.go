@@ -0,0 +1,169 @@
+package netem
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TracePacketSize is the number of bytes delivered per opportunity of a Trace, as in Mahimahi.
+const TracePacketSize = 1500
+
+// Trace is a Mahimahi packet delivery trace.
+// Every line is a timestamp in milliseconds at which TracePacketSize bytes can be delivered,
+// repeated timestamps are multiple opportunities.
+// The trace repeats after its last timestamp.
+type Trace struct {
+	Path          string
+	opportunities []time.Duration
+	period        time.Duration
+}
+
+// LoadTrace reads a Mahimahi trace file.
+func LoadTrace(path string) (*Trace, error) {
+	var opportunities []time.Duration
+	err := readTraceLines(path, func(fields []string) error {
+		if len(fields) != 1 {
+			return fmt.Errorf("expected a timestamp")
+		}
+		ms, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		opportunities = append(opportunities, time.Duration(ms)*time.Millisecond)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(opportunities) == 0 {
+		return nil, fmt.Errorf("empty trace %s", path)
+	}
+	if !sort.SliceIsSorted(opportunities, func(i, j int) bool { return opportunities[i] < opportunities[j] }) {
+		return nil, fmt.Errorf("timestamps of %s are not sorted", path)
+	}
+	period := opportunities[len(opportunities)-1]
+	if period == 0 {
+		return nil, fmt.Errorf("trace %s lasts 0 ms", path)
+	}
+	return &Trace{
+		Path:          path,
+		opportunities: opportunities,
+		period:        period,
+	}, nil
+}
+
+// traceCursor is a delivery opportunity of a Trace.
+type traceCursor struct {
+	cycle int64
+	index int
+}
+
+func (t *Trace) time(c traceCursor) time.Duration {
+	return time.Duration(c.cycle)*t.period + t.opportunities[c.index]
+}
+
+func (t *Trace) next(c traceCursor) traceCursor {
+	c.index++
+	if c.index == len(t.opportunities) {
+		c.cycle++
+		c.index = 0
+	}
+	return c
+}
+
+// at returns the first opportunity at or after offset.
+func (t *Trace) at(offset time.Duration) traceCursor {
+	c := traceCursor{cycle: int64(offset / t.period)}
+	inCycle := offset % t.period
+	c.index = sort.Search(len(t.opportunities), func(i int) bool { return t.opportunities[i] >= inCycle })
+	if c.index == len(t.opportunities) {
+		c.cycle++
+		c.index = 0
+	}
+	return c
+}
+
+// DelayTrace is a one-way delay varying over time.
+// Every line is a timestamp and a delay, both in milliseconds, the delay holds until the next timestamp.
+// The trace repeats after its last timestamp.
+type DelayTrace struct {
+	Path       string
+	timestamps []time.Duration
+	delays     []time.Duration
+	period     time.Duration
+}
+
+// LoadDelayTrace reads a delay trace file.
+func LoadDelayTrace(path string) (*DelayTrace, error) {
+	d := &DelayTrace{Path: path}
+	err := readTraceLines(path, func(fields []string) error {
+		if len(fields) != 2 {
+			return fmt.Errorf("expected a timestamp and a delay")
+		}
+		timestamp, err := strconv.ParseUint(fields[0], 10, 64)
+		if err != nil {
+			return err
+		}
+		delay, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return err
+		}
+		if delay < 0 {
+			return fmt.Errorf("negative delay")
+		}
+		t := time.Duration(timestamp) * time.Millisecond
+		if len(d.timestamps) > 0 && t <= d.timestamps[len(d.timestamps)-1] {
+			return fmt.Errorf("timestamps are not increasing")
+		}
+		d.timestamps = append(d.timestamps, t)
+		d.delays = append(d.delays, time.Duration(delay*float64(time.Millisecond)))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(d.timestamps) == 0 {
+		return nil, fmt.Errorf("empty delay trace %s", path)
+	}
+	d.period = d.timestamps[len(d.timestamps)-1]
+	return d, nil
+}
+
+// at returns the delay at offset since the start of the trace.
+func (d *DelayTrace) at(offset time.Duration) time.Duration {
+	if d.period > 0 {
+		offset %= d.period
+	}
+	// the last entry whose timestamp is not after offset, the first before the first timestamp
+	i := sort.Search(len(d.timestamps), func(i int) bool { return d.timestamps[i] > offset }) - 1
+	if i < 0 {
+		i = 0
+	}
+	return d.delays[i]
+}
+
+// readTraceLines calls parse with the fields of every line, empty lines and lines starting with # are skipped.
+func readTraceLines(path string, parse func(fields []string) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		err = parse(strings.Fields(text))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %w", path, line, err)
+		}
+	}
+	return scanner.Err()
+}
@@ -7,7 +7,13 @@ import (
 	"os"
 	"qperf-go/client"
 	"qperf-go/common"
+	"qperf-go/internal/congestion/brutal"
+	"qperf-go/internal/congestion/rl"
+	"qperf-go/internal/netem"
 	"qperf-go/server"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -49,7 +55,15 @@ func main() {
 					},
 					&cli.UintFlag{
 						Name:  "migrate",
-						Usage: "seconds after which the udp socket is migrated",
+						Usage: "seconds after the first byte after which the udp socket is migrated",
+					},
+					&cli.StringFlag{
+						Name:  "migrate-at",
+						Usage: "comma separated times after the first byte at which the udp socket is migrated, e.g. 5s,10.5s",
+					},
+					&cli.StringFlag{
+						Name:  "migrate-addr",
+						Usage: "comma separated local IP addresses the udp socket is bound to in turn on migration, defaults to a new port on the same address",
 					},
 					&cli.StringFlag{
 						Name:  "proxy",
@@ -60,6 +74,19 @@ func main() {
 						Usage: "run for this many seconds",
 						Value: 10,
 					},
+					&cli.StringFlag{
+						Name:  "n",
+						Usage: "download this many bytes per stream instead of running for a time, and report the flow completion time, in bytes (e.g. 1GiB)",
+					},
+					&cli.StringFlag{
+						Name:  "fct",
+						Usage: "measure the flow completion time of downloads one after another, each on a new connection, with comma separated sizes and optional weights, e.g. 10KiB:0.7,100KiB:0.2,1MiB:0.1",
+					},
+					&cli.UintFlag{
+						Name:  "fct-flows",
+						Usage: "number of flows measured with fct",
+						Value: 100,
+					},
 					&cli.Float64Flag{
 						Name:    "report-interval",
 						Aliases: []string{"i"},
@@ -93,9 +120,22 @@ func main() {
 					},
 					&cli.BoolFlag{
 						Name:  "0rtt",
-						Usage: "gather 0-RTT information to the server beforehand",
+						Usage: "gather 0-RTT information to the server beforehand and send the test parameters in 0-RTT",
 						Value: false,
 					},
+					&cli.DurationFlag{
+						Name:  "0rtt-timeout",
+						Usage: "how long to wait for the session ticket and token of the server before 0-RTT",
+						Value: 5 * time.Second,
+					},
+					&cli.BoolFlag{
+						Name:  "compare-0rtt",
+						Usage: "compare the time to first byte of a 1-RTT connection and a 0-RTT connection to the server afterwards",
+					},
+					&cli.StringFlag{
+						Name:  "session-dir",
+						Usage: "directory to store the session tickets and address tokens per server name in, so later runs can resume the session and use 0-RTT without gathering them first",
+					},
 					&cli.BoolFlag{
 						Name:  "proxy-0rtt",
 						Usage: "gather 0-RTT information to the proxy beforehand",
@@ -129,6 +169,44 @@ func main() {
 						Name:  "quiet",
 						Usage: "don't print the data in http3",
 					},
+					&cli.BoolFlag{
+						Name:    "reverse",
+						Aliases: []string{"R"},
+						Usage:   "upload mode, the client sends data and the server reports the received bytes",
+					},
+					&cli.BoolFlag{
+						Name:  "bidir",
+						Usage: "send data in both directions at the same time",
+					},
+					&cli.UintFlag{
+						Name:    "parallel",
+						Aliases: []string{"P"},
+						Usage:   "number of parallel streams per direction",
+						Value:   1,
+					},
+					&cli.UintFlag{
+						Name:  "connections",
+						Usage: "number of parallel QUIC connections",
+						Value: 1,
+					},
+					&cli.StringFlag{
+						Name:  "block-size",
+						Usage: "the size of a single write on a data stream, in bytes",
+						Value: "64KiB",
+					},
+					&cli.StringFlag{
+						Name:  "cc",
+						Usage: "request a congestion control of the server for this test (cubic, reno, bbr, brutal, rl, rl-static), defaults to the cc of the server, cubic, reno and bbr are also used by the client for sending",
+					},
+					&cli.StringFlag{
+						Name:  "rx-bandwidth",
+						Usage: "announce the receive bandwidth of the client, used by the server as the brutal rate, in bytes per second or with a bit rate suffix (e.g. 100Mbps)",
+						Value: "0",
+					},
+					&cli.StringFlag{
+						Name:  "netem",
+						Usage: "emulate the link for the packets sent by the client, e.g. bw=10Mbps,delay=20ms,jitter=2ms,loss=0.01,ge=0.01:0.3,reorder=0.01,dup=0.001,queue=64KiB,red=20KiB:60KiB:0.1,seed=1",
+					},
 				},
 				Action: func(c *cli.Context) error {
 					var proxyAddr *net.UDPAddr
@@ -152,12 +230,75 @@ func main() {
 					if err != nil {
 						return fmt.Errorf("failed to parse receive-window: %w", err)
 					}
+					if c.Uint("parallel") < 1 {
+						return fmt.Errorf("parallel must be at least 1")
+					}
+					if c.Uint("connections") < 1 {
+						return fmt.Errorf("connections must be at least 1")
+					}
+					blockSize, err := common.ParseByteCountWithUnit(c.String("block-size"))
+					if err != nil {
+						return fmt.Errorf("failed to parse block-size: %w", err)
+					}
+					var byteLimit uint64
+					if c.String("n") != "" {
+						byteLimit, err = common.ParseByteCountWithUnit(c.String("n"))
+						if err != nil {
+							return fmt.Errorf("failed to parse n: %w", err)
+						}
+					}
+					fctSizes, err := common.ParseFlowSizeDistribution(c.String("fct"))
+					if err != nil {
+						return fmt.Errorf("failed to parse fct: %w", err)
+					}
+					if byteLimit != 0 && fctSizes != nil {
+						return fmt.Errorf("n and fct cannot be combined")
+					}
+					if (byteLimit != 0 || fctSizes != nil) && (c.Bool("reverse") || c.Bool("bidir")) {
+						return fmt.Errorf("n and fct are only supported for downloads")
+					}
+					if fctSizes != nil && (c.Uint("parallel") > 1 || c.Uint("connections") > 1) {
+						return fmt.Errorf("fct uses a single stream and connection per flow")
+					}
+					rxBandwidth, err := common.ParseBandwidth(c.String("rx-bandwidth"))
+					if err != nil {
+						return fmt.Errorf("failed to parse rx-bandwidth: %w", err)
+					}
+					netemConf, err := netem.ParseConfig(c.String("netem"))
+					if err != nil {
+						return fmt.Errorf("failed to parse netem: %w", err)
+					}
+					var migrateTimes []time.Duration
+					if c.Uint64("migrate") != 0 {
+						migrateTimes = append(migrateTimes, time.Duration(c.Uint64("migrate"))*time.Second)
+					}
+					if c.String("migrate-at") != "" {
+						for _, v := range strings.Split(c.String("migrate-at"), ",") {
+							t, err := time.ParseDuration(strings.TrimSpace(v))
+							if err != nil {
+								return fmt.Errorf("failed to parse migrate-at: %w", err)
+							}
+							migrateTimes = append(migrateTimes, t)
+						}
+					}
+					sort.Slice(migrateTimes, func(i, j int) bool { return migrateTimes[i] < migrateTimes[j] })
+					var migrateAddrs []net.IP
+					if c.String("migrate-addr") != "" {
+						for _, v := range strings.Split(c.String("migrate-addr"), ",") {
+							ip := net.ParseIP(strings.TrimSpace(v))
+							if ip == nil {
+								return fmt.Errorf("invalid migrate-addr: %s", v)
+							}
+							migrateAddrs = append(migrateAddrs, ip)
+						}
+					}
 					client.Run(
 						*serverAddr,
 						c.Bool("ttfb"),
 						c.Bool("print-raw"),
 						c.Bool("qlog"),
-						time.Duration(c.Uint64("migrate"))*time.Second,
+						migrateTimes,
+						migrateAddrs,
 						proxyAddr,
 						time.Duration(c.Uint("t"))*time.Second,
 						time.Duration(c.Float64("report-interval")*float64(time.Second)),
@@ -167,6 +308,7 @@ func main() {
 						initialReceiveWindow,
 						maxReceiveWindow,
 						c.Bool("0rtt"),
+						c.Duration("0rtt-timeout"),
 						c.Bool("proxy-0rtt"),
 						c.Bool("early-handover"),
 						c.Bool("xse"),
@@ -174,6 +316,19 @@ func main() {
 						c.String("qlog-prefix"),
 						c.Bool("http3"),
 						c.Bool("quiet"),
+						c.Bool("reverse"),
+						c.Bool("bidir"),
+						c.Uint("parallel"),
+						c.Uint("connections"),
+						blockSize,
+						byteLimit,
+						c.String("cc"),
+						rxBandwidth,
+						netemConf,
+						c.Bool("compare-0rtt"),
+						c.String("session-dir"),
+						fctSizes,
+						c.Uint("fct-flows"),
 						c.Args(),
 					)
 					return nil
@@ -199,7 +354,7 @@ func main() {
 					},
 					&cli.UintFlag{
 						Name:  "migrate",
-						Usage: "seconds after which the udp socket is migrated",
+						Usage: "not supported, only clients can migrate",
 					},
 					&cli.StringFlag{
 						Name:  "tls-cert",
@@ -241,6 +396,10 @@ func main() {
 						Usage: "disable XSE-QUIC extension; XSE-QUIC handshakes will fail",
 						Value: false,
 					},
+					&cli.BoolFlag{
+						Name:  "no-0rtt",
+						Usage: "reject 0-RTT, session tickets are still issued for 1-RTT resumption",
+					},
 					&cli.StringFlag{
 						Name:  "qlog-prefix",
 						Usage: "the prefix of the qlog file name",
@@ -261,14 +420,91 @@ func main() {
 					},
 					&cli.StringFlag{
 						Name:  "redis",
-						Usage: "redis addr [host:port]",
+						Usage: "redis addr [host:port], used by the rl cc if no rl-transport is set",
 						Value: "localhost:6379",
 					},
+					&cli.StringFlag{
+						Name:  "rl-transport",
+						Usage: "transport to the agent of the rl cc: redis://host:port, unix:///path, tcp://host:port or grpc://host:port",
+					},
+					&cli.StringFlag{
+						Name:  "rl-action-space",
+						Usage: "how the actions of the rl agent are applied: discrete (cwnd deltas in packets), multiplicative (cwnd factors), pacing (pacing rate in bytes/s) or continuous (cwnd factor 2^value)",
+						Value: rl.ActionSpaceDiscrete,
+					},
+					&cli.StringFlag{
+						Name:  "rl-action-values",
+						Usage: "comma separated values of the discrete or multiplicative actions, e.g. -3,-1,0,1,3",
+					},
+					&cli.StringFlag{
+						Name:  "rl-min-cwnd",
+						Usage: "the minimum congestion window of the rl cc, in bytes, 0 for 2 packets",
+						Value: "0",
+					},
+					&cli.StringFlag{
+						Name:  "rl-max-cwnd",
+						Usage: "the maximum congestion window of the rl cc, in bytes, 0 for the maximum of quic-go",
+						Value: "0",
+					},
+					&cli.Float64Flag{
+						Name:  "rl-step-rtts",
+						Usage: "synchronous step mode of the rl cc: publish a state every n smoothed RTTs and wait for the action of the same seq, 0 to publish every second and apply actions asynchronously",
+						Value: 0,
+					},
+					&cli.DurationFlag{
+						Name:  "rl-step-timeout",
+						Usage: "how long a step waits for the action of the rl agent before applying the default action",
+						Value: 200 * time.Millisecond,
+					},
+					&cli.StringFlag{
+						Name:  "rl-step-default-action",
+						Usage: "action applied on step timeouts, an index for the discrete and multiplicative action spaces and a value otherwise, empty to keep the cwnd",
+					},
+					&cli.StringFlag{
+						Name:  "rl-reward-weights",
+						Usage: "comma separated weights of the rl reward: throughput (per Mbit/s), queuing delay (per ms) and loss rate (per 1.0)",
+						Value: fmt.Sprintf("%v,%v,%v", rl.DefaultRewardThroughputWeight, rl.DefaultRewardDelayWeight, rl.DefaultRewardLossWeight),
+					},
+					&cli.StringFlag{
+						Name:  "rl-episode-log",
+						Usage: "JSONL file to write the state, action and reward of every rl step to",
+					},
+					&cli.StringFlag{
+						Name:  "rl-policy",
+						Usage: "JSON file of a frozen lookup table or linear policy, used by the rl-static cc",
+					},
+					&cli.StringFlag{
+						Name:  "rl-replay",
+						Usage: "episode log (see rl-episode-log) whose actions are replayed by the rl-static cc",
+					},
+					&cli.StringFlag{
+						Name:  "rl-replay-connection",
+						Usage: "connection id of the episode log to replay, defaults to the first connection",
+					},
+					&cli.StringFlag{
+						Name:  "netem",
+						Usage: "emulate the link for the packets sent by the server, see the netem option of the client",
+					},
 					&cli.StringFlag{
 						Name:  "cc",
-						Usage: "congestion algorithm,default Cubic, available [cubic,rl]",
+						Usage: "congestion algorithm,default Cubic, available [cubic,reno,bbr,brutal,rl,rl-static]",
 						Value: common.CC_CUBIC,
 					},
+					&cli.StringFlag{
+						Name:  "brutal-rate",
+						Usage: "the sending rate of brutal if the client does not announce its receive bandwidth, in bytes per second or with a bit rate suffix (e.g. 100Mbps)",
+						Value: "5MiB",
+					},
+					&cli.Float64Flag{
+						Name:  "brutal-ack-rate-floor",
+						Usage: "the lowest ack rate brutal uses to compensate losses, between 0 and 1",
+						Value: brutal.DefaultMinAckRate,
+					},
+					&cli.Float64Flag{
+						Name:  "brutal-cwnd-multiplier",
+						Usage: "the congestion window of brutal in multiples of the bandwidth-delay product",
+						Value: brutal.DefaultCongestionWindowMultiplier,
+					},
 				},
 				Action: func(c *cli.Context) error {
 					initialReceiveWindow, err := common.ParseByteCountWithUnit(c.String("initial-receive-window"))
@@ -279,6 +515,53 @@ func main() {
 					if err != nil {
 						return fmt.Errorf("failed to parse receive-window: %w", err)
 					}
+					brutalRate, err := common.ParseBandwidth(c.String("brutal-rate"))
+					if err != nil {
+						return fmt.Errorf("failed to parse brutal-rate: %w", err)
+					}
+					if c.Float64("brutal-ack-rate-floor") <= 0 || c.Float64("brutal-ack-rate-floor") > 1 {
+						return fmt.Errorf("brutal-ack-rate-floor must be between 0 and 1")
+					}
+					if c.Float64("brutal-cwnd-multiplier") <= 0 {
+						return fmt.Errorf("brutal-cwnd-multiplier must be positive")
+					}
+					var rlActionValues []float64
+					if c.String("rl-action-values") != "" {
+						for _, v := range strings.Split(c.String("rl-action-values"), ",") {
+							value, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+							if err != nil {
+								return fmt.Errorf("failed to parse rl-action-values: %w", err)
+							}
+							rlActionValues = append(rlActionValues, value)
+						}
+					}
+					var rlRewardWeights [3]float64
+					weights := strings.Split(c.String("rl-reward-weights"), ",")
+					if len(weights) != len(rlRewardWeights) {
+						return fmt.Errorf("rl-reward-weights needs %d values", len(rlRewardWeights))
+					}
+					for i, v := range weights {
+						rlRewardWeights[i], err = strconv.ParseFloat(strings.TrimSpace(v), 64)
+						if err != nil {
+							return fmt.Errorf("failed to parse rl-reward-weights: %w", err)
+						}
+					}
+					rlMinCwnd, err := common.ParseByteCountWithUnit(c.String("rl-min-cwnd"))
+					if err != nil {
+						return fmt.Errorf("failed to parse rl-min-cwnd: %w", err)
+					}
+					rlMaxCwnd, err := common.ParseByteCountWithUnit(c.String("rl-max-cwnd"))
+					if err != nil {
+						return fmt.Errorf("failed to parse rl-max-cwnd: %w", err)
+					}
+					netemConf, err := netem.ParseConfig(c.String("netem"))
+					if err != nil {
+						return fmt.Errorf("failed to parse netem: %w", err)
+					}
+					rlTransport := c.String("rl-transport")
+					if rlTransport == "" {
+						rlTransport = "redis://" + c.String("redis")
+					}
 					server.Run(net.UDPAddr{
 						IP:   net.ParseIP(c.String("addr")),
 						Port: c.Int("port"),
@@ -293,12 +576,29 @@ func main() {
 						initialReceiveWindow,
 						maxReceiveWindow,
 						c.Bool("no-xse"),
+						!c.Bool("no-0rtt"),
 						c.String("log-prefix"),
 						c.String("qlog-prefix"),
 						c.Bool("http3"),
 						c.String("www"),
-						c.String("redis"),
+						rlTransport,
 						c.String("cc"),
+						brutalRate,
+						c.Float64("brutal-ack-rate-floor"),
+						c.Float64("brutal-cwnd-multiplier"),
+						c.String("rl-action-space"),
+						rlActionValues,
+						rlMinCwnd,
+						rlMaxCwnd,
+						c.Float64("rl-step-rtts"),
+						c.Duration("rl-step-timeout"),
+						c.String("rl-step-default-action"),
+						rlRewardWeights,
+						c.String("rl-episode-log"),
+						c.String("rl-policy"),
+						c.String("rl-replay"),
+						c.String("rl-replay-connection"),
+						netemConf,
 					)
 					return nil
 				},
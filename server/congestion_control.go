@@ -0,0 +1,172 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dustin/go-humanize"
+	"os"
+	"qperf-go/common"
+	"qperf-go/internal/congestion"
+	"qperf-go/internal/congestion/rl"
+	"slices"
+	"sync"
+	"time"
+)
+
+// supportedCongestionControls can be selected by the server default or requested by the client.
+var supportedCongestionControls = []string{
+	common.CC_CUBIC,
+	common.CC_RENO,
+	common.CC_BBR,
+	common.CC_BRUTAL,
+	common.CC_RL,
+	common.CC_RL_STATIC,
+}
+
+// bbrStateReportInterval is the interval in which the internal state of BBR is logged.
+const bbrStateReportInterval = time.Second
+
+// lazyRLTransport connects to the agent of the rl cc on first use and is shared by all connections.
+// It drops the connection to the agent if publishing or listening fails, the next call reconnects.
+type lazyRLTransport struct {
+	mutex     sync.Mutex
+	uri       string
+	transport rl.Transport
+}
+
+// get returns the transport, a failed connection attempt is retried on the next call.
+func (l *lazyRLTransport) get() (rl.Transport, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.transport == nil {
+		transport, err := rl.NewTransport(l.uri)
+		if err != nil {
+			return nil, err
+		}
+		l.transport = transport
+	}
+	return l.transport, nil
+}
+
+// drop closes the transport unless it was already replaced.
+func (l *lazyRLTransport) drop(transport rl.Transport) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.transport == transport {
+		_ = l.transport.Close()
+		l.transport = nil
+	}
+}
+
+func (l *lazyRLTransport) Publish(channel, message string) error {
+	transport, err := l.get()
+	if err != nil {
+		return err
+	}
+	err = transport.Publish(channel, message)
+	if err != nil {
+		l.drop(transport)
+	}
+	return err
+}
+
+func (l *lazyRLTransport) Listen(ctx context.Context, channel string) (<-chan string, error) {
+	transport, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	messages, err := transport.Listen(ctx, channel)
+	if err != nil {
+		l.drop(transport)
+	}
+	return messages, err
+}
+
+func (l *lazyRLTransport) Close() error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.transport == nil {
+		return nil
+	}
+	err := l.transport.Close()
+	l.transport = nil
+	return err
+}
+
+func isSupportedCongestionControl(cc string) bool {
+	return slices.Contains(supportedCongestionControls, cc)
+}
+
+// useCongestionControl replaces the congestion control of the connection.
+func (s *qperfServerSession) useCongestionControl() error {
+	switch s.cc {
+	case common.CC_CUBIC:
+		congestion.UseCubic(s.connection, false)
+	case common.CC_RENO:
+		congestion.UseCubic(s.connection, true)
+	case common.CC_BBR:
+		s.bbrState = congestion.UseBBR(s.connection)
+	case common.CC_RL, common.CC_RL_STATIC:
+		return s.useRL()
+	case common.CC_BRUTAL:
+		// the receive bandwidth announced by the client replaces the rate of the server
+		brutalConf := *s.brutalConf
+		if s.parameters.TargetRate != 0 {
+			brutalConf.Bps = s.parameters.TargetRate
+		}
+		congestion.UseBrutal(s.connection, &brutalConf)
+		s.logger.Infof("brutal rate: %s", humanize.SIWithDigits(float64(brutalConf.Bps)*8, 2, "bit/s"))
+	default:
+		return fmt.Errorf("invalid cc: %s", s.cc)
+	}
+	return nil
+}
+
+// useRL connects the rl cc to the external agent, or to the policy for rl-static.
+func (s *qperfServerSession) useRL() error {
+	// unique across connections and server processes sharing the transport
+	rlConnectionID := fmt.Sprintf("qperf-%d-%d", os.Getpid(), s.connectionID)
+	var transport rl.Transport
+	if s.cc == common.CC_RL_STATIC {
+		if s.newPolicy == nil {
+			return errors.New("no rl policy configured")
+		}
+		transport = s.policyTransport
+		err := rl.ServePolicy(s.connection.Context(), transport, rlConnectionID, s.newPolicy())
+		if err != nil {
+			return err
+		}
+	} else {
+		// connect now, so the connection fails early if the agent is unavailable
+		_, err := s.rlTransport.get()
+		if err != nil {
+			return fmt.Errorf("rl transport unavailable: %w", err)
+		}
+		transport = s.rlTransport
+	}
+	var ecnCounter rl.ECNCounter
+	if counter := s.ecnCounters.Get(s.connection.Context()); counter != nil {
+		ecnCounter = counter
+	}
+	congestion.UseRL(s.connection, transport, rlConnectionID, ecnCounter, s.actionSpace, s.stepConfig, s.reward, s.episodeLog)
+	s.logger.Infof("rl channels: /%s/state, /%s/action, %s action space", rlConnectionID, rlConnectionID, s.actionSpace.Type)
+	if s.stepConfig != nil {
+		s.logger.Infof("rl step every %v RTTs, timeout %s", s.stepConfig.Interval, s.stepConfig.Timeout)
+	}
+	return nil
+}
+
+// reportBBRState logs the internal state of BBR until the connection is closed.
+func (s *qperfServerSession) reportBBRState() {
+	ticker := time.NewTicker(bbrStateReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.connection.Context().Done():
+			return
+		case <-ticker.C:
+			s.logger.Infof("bbr: %s", s.bbrState.State())
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net"
+	"qperf-go/internal/congestion/rl"
+	"testing"
+	"time"
+)
+
+func TestLazyRLTransport_ReconnectsAfterError(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 2)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- conn
+		}
+	}()
+
+	transport := &lazyRLTransport{uri: "tcp://" + listener.Addr().String()}
+	defer transport.Close()
+	err = transport.Publish("/registry", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := transport.transport
+	// the agent goes away, writes fail once the reset arrived
+	(<-accepted).Close()
+	deadline := time.Now().Add(5 * time.Second)
+	for transport.Publish("/registry", "{}") == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("publishing to the closed socket did not fail")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if transport.transport != nil {
+		t.Fatal("the failed transport is still cached")
+	}
+
+	err = transport.Publish("/registry", "{}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case conn := <-accepted:
+		conn.Close()
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reconnect")
+	}
+	if transport.transport == first {
+		t.Fatal("the failed transport was reused")
+	}
+}
+
+// serveAgent answers the states of every connection to the agent with an action of the same seq,
+// the accepted connections and the received registry messages are reported.
+func serveAgent(listener net.Listener, accepted chan<- net.Conn, registered chan<- rl.RegistryMsg) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		accepted <- conn
+		go func() {
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				envelope := rl.Envelope{}
+				if json.Unmarshal(scanner.Bytes(), &envelope) != nil {
+					return
+				}
+				if envelope.Channel == rl.RegistryChannel {
+					registryMsg := rl.RegistryMsg{}
+					_ = json.Unmarshal(envelope.Message, &registryMsg)
+					registered <- registryMsg
+					continue
+				}
+				state := rl.StateMsg{}
+				_ = json.Unmarshal(envelope.Message, &state)
+				action, _ := json.Marshal(&rl.ActionMsg{Seq: state.Seq, Action: 1})
+				body, _ := json.Marshal(&rl.Envelope{Channel: "/conn1/action", Message: action})
+				_, _ = conn.Write(append(body, '\n'))
+			}
+		}()
+	}
+}
+
+func TestLazyRLTransport_ListenActionAfterReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	accepted := make(chan net.Conn, 2)
+	registered := make(chan rl.RegistryMsg, 2)
+	go serveAgent(listener, accepted, registered)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	transport := &lazyRLTransport{uri: "tcp://" + listener.Addr().String()}
+	defer transport.Close()
+	qm := rl.NewQuicMqManager(transport, "conn1")
+	go qm.ListenAction(ctx)
+	expectAction := func() {
+		// the listener may not be subscribed yet
+		for {
+			err := qm.PublishState(&rl.StateMsg{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case _, ok := <-qm.GetActionCh():
+				if !ok {
+					t.Fatal("action channel closed")
+				}
+				return
+			case <-time.After(10 * time.Millisecond):
+			case <-ctx.Done():
+				t.Fatal("no action received")
+			}
+		}
+	}
+	expectAction()
+
+	// the agent goes away, ListenAction listens again on a new connection and announces the connection
+	(<-accepted).Close()
+	select {
+	case conn := <-accepted:
+		defer conn.Close()
+	case <-ctx.Done():
+		t.Fatal("no reconnect")
+	}
+	select {
+	case registryMsg := <-registered:
+		if registryMsg.Event != rl.RegistryEventNew || registryMsg.ActionChannel != "/conn1/action" {
+			t.Fatalf("unexpected registry message: %+v", registryMsg)
+		}
+	case <-ctx.Done():
+		t.Fatal("connection not announced again")
+	}
+	expectAction()
+
+	cancel()
+	for range qm.GetActionCh() {
+	}
+}
@@ -5,23 +5,70 @@ import (
 	"fmt"
 	"github.com/apernet/quic-go"
 	"qperf-go/common"
+	"qperf-go/internal/congestion/bbr"
+	"qperf-go/internal/congestion/brutal"
+	"qperf-go/internal/congestion/rl"
 	"sync"
 )
 
+// maxIncomingStreams is the number of streams a client is allowed to open, including the control stream.
+const maxIncomingStreams = 100
+
 type qperfServerSession struct {
 	connection   quic.Connection
 	connectionID uint64
 	// used to detect migration
 	logger    common.Logger
 	closeOnce sync.Once
+	// congestion control used for this connection, the server default until the client requests another one
+	cc          string
+	rlTransport *lazyRLTransport
+	actionSpace *rl.ActionSpace
+	// nil for the asynchronous rl mode
+	stepConfig *rl.StepConfig
+	reward     *rl.Reward
+	// nil if no rl episodes are recorded
+	episodeLog *rl.EpisodeLog
+	// creates the policy of a rl-static connection, nil if no policy is configured
+	newPolicy func() rl.Policy
+	// connects rl-static connections to their policies
+	policyTransport *rl.ChannelTransport
+	brutalConf      *brutal.Config
+	// ECN-CE marks of all connections
+	ecnCounters *common.ECNCounters
+	// set if BBR is used
+	bbrState bbr.StateProvider
+	// the test parameters received from the client
+	parameters common.TestParameters
 }
 
 func (s *qperfServerSession) run() {
 	s.logger.Infof("open")
+	if early, ok := s.connection.(quic.EarlyConnection); ok {
+		go func() {
+			if common.AwaitHandshake(early) != nil {
+				return
+			}
+			if early.ConnectionState().Used0RTT {
+				s.logger.Infof("accepted 0-RTT")
+			}
+		}()
+	}
 	// if s.connection.ExtraStreamEncrypted() {
 	// 	s.logger.Infof("use XSE-QUIC")
 	// }
 
+	controlStream, err := s.connection.AcceptStream(context.Background())
+	if err != nil {
+		s.close(err)
+		return
+	}
+	err = s.handleHello(controlStream)
+	if err != nil {
+		s.close(err)
+		return
+	}
+
 	for {
 		quicStream, err := s.connection.AcceptStream(context.Background())
 		if err != nil {
@@ -39,6 +86,72 @@ func (s *qperfServerSession) run() {
 	}
 }
 
+// handleHello reads the test parameters from the control stream and responds with the server capabilities.
+func (s *qperfServerSession) handleHello(controlStream quic.Stream) error {
+	capabilities := common.ServerCapabilities{
+		Version:            common.ControlProtocolVersion,
+		Directions:         []string{common.DirectionDownload, common.DirectionUpload, common.DirectionBidirectional},
+		CongestionControls: supportedCongestionControls,
+		MaxStreams:         maxIncomingStreams - 1,
+		MaxBlockSize:       common.MaxBlockSize,
+	}
+
+	err := common.ReadControlMessage(controlStream, common.ControlMessageHello, &s.parameters)
+	if err == nil {
+		err = s.validateParameters(&capabilities)
+	}
+	if err == nil {
+		if s.parameters.CongestionControl != "" {
+			s.cc = s.parameters.CongestionControl
+		}
+		err = s.useCongestionControl()
+	}
+	if err != nil {
+		capabilities.Error = err.Error()
+		_ = common.WriteControlMessage(controlStream, common.ControlMessageHelloAck, &capabilities)
+		return fmt.Errorf("rejected test: %w", err)
+	}
+	s.logger.Infof("using %s cc", s.cc)
+	if s.bbrState != nil {
+		go s.reportBBRState()
+	}
+
+	capabilities.Accepted = true
+	capabilities.CongestionControl = s.cc
+	err = common.WriteControlMessage(controlStream, common.ControlMessageHelloAck, &capabilities)
+	if err != nil {
+		return err
+	}
+	s.logger.Infof("test parameters: direction %s, duration %s, streams %d, block size %d B, byte limit %d B",
+		s.parameters.Direction,
+		s.parameters.Duration,
+		s.parameters.Streams,
+		s.parameters.BlockSize,
+		s.parameters.ByteLimit)
+	return nil
+}
+
+func (s *qperfServerSession) validateParameters(capabilities *common.ServerCapabilities) error {
+	streams := s.parameters.Streams
+	switch s.parameters.Direction {
+	case common.DirectionDownload, common.DirectionUpload:
+	case common.DirectionBidirectional:
+		streams *= 2
+	default:
+		return fmt.Errorf("unsupported direction: %s", s.parameters.Direction)
+	}
+	if streams == 0 || streams > capabilities.MaxStreams {
+		return fmt.Errorf("unsupported number of streams: %d", streams)
+	}
+	if s.parameters.BlockSize == 0 || s.parameters.BlockSize > capabilities.MaxBlockSize {
+		return fmt.Errorf("unsupported block size: %d", s.parameters.BlockSize)
+	}
+	if s.parameters.CongestionControl != "" && !isSupportedCongestionControl(s.parameters.CongestionControl) {
+		return fmt.Errorf("unsupported congestion control: %s", s.parameters.CongestionControl)
+	}
+	return nil
+}
+
 func (s *qperfServerSession) close(err error) {
 	s.closeOnce.Do(func() {
 		switch err := err.(type) {
@@ -3,36 +3,108 @@ package server
 import (
 	"fmt"
 	"github.com/apernet/quic-go"
-	"io"
+	"github.com/dustin/go-humanize"
 	"qperf-go/common"
+	"time"
 )
 
 type qperfServerStream struct {
 	session *qperfServerSession
 	stream  quic.Stream
 	logger  common.Logger
+	state   common.State
 }
 
 func (s *qperfServerStream) run() {
 	s.logger.Infof("open")
 
-	request, err := io.ReadAll(s.stream)
+	request := common.StreamRequest{}
+	err := common.ReadControlMessage(s.stream, common.ControlMessageStreamRequest, &request)
 	if err != nil {
 		s.session.close(err)
 		s.logger.Errorf("%s", err)
 		return
 	}
-	if string(request) != common.QPerfStartSendingRequest {
-		s.session.close(fmt.Errorf("unknown qperf message"))
+
+	switch request.Direction {
+	case common.DirectionDownload:
+		s.send()
+	case common.DirectionUpload:
+		s.receive()
+	default:
+		s.session.close(fmt.Errorf("unknown direction: %s", request.Direction))
+	}
+}
+
+// send sends data until the connection is closed or the byte limit is reached.
+func (s *qperfServerStream) send() {
+	buf := make([]byte, s.session.parameters.BlockSize)
+	byteLimit := s.session.parameters.ByteLimit
+	var sentBytes uint64
+	for byteLimit == 0 || sentBytes < byteLimit {
+		if byteLimit != 0 && byteLimit-sentBytes < uint64(len(buf)) {
+			buf = buf[:byteLimit-sentBytes]
+		}
+		sent, err := s.stream.Write(buf)
+		sentBytes += uint64(sent)
+		if err != nil {
+			s.logger.Infof("close, bytes sent: %s", humanize.SI(float64(sentBytes), "B"))
+			s.session.close(err)
+			return
+		}
+	}
+	err := s.stream.Close()
+	if err != nil {
+		s.session.close(err)
 		return
 	}
+	s.logger.Infof("byte limit reached, bytes sent: %s", humanize.SI(float64(sentBytes), "B"))
+}
+
+// receive discards all incoming data and reports the received bytes back to the client.
+func (s *qperfServerStream) receive() {
+	s.state.SetStartTime()
+	firstByte := make(chan struct{})
+	go s.sendReports(firstByte)
 
-	buf := make([]byte, 65536)
+	buf := make([]byte, s.session.parameters.BlockSize)
+	receivedFirstByte := false
 	for {
-		_, err := s.stream.Write(buf)
+		received, err := s.stream.Read(buf)
+		s.state.AddReceivedBytes(uint64(received))
+		if received != 0 && !receivedFirstByte {
+			receivedFirstByte = true
+			close(firstByte)
+		}
 		if err != nil {
+			receivedBytes, _ := s.state.Total()
+			s.logger.Infof("close, bytes received: %s", humanize.SI(float64(receivedBytes), "B"))
 			s.session.close(err)
 			return
 		}
 	}
 }
+
+// sendReports sends a report as soon as the first byte is received
+// and then every common.ReceiveReportInterval.
+func (s *qperfServerStream) sendReports(firstByte <-chan struct{}) {
+	select {
+	case <-firstByte:
+	case <-s.stream.Context().Done():
+		return
+	}
+	ticker := time.NewTicker(common.ReceiveReportInterval)
+	defer ticker.Stop()
+	for {
+		receivedBytes, receivedPackets, delta := s.state.GetAndResetReport()
+		err := common.WriteControlMessage(s.stream, common.ControlMessageReceiveReport, &common.ReceiveReport{
+			Bytes:   receivedBytes,
+			Packets: receivedPackets,
+			Delta:   delta,
+		})
+		if err != nil {
+			return
+		}
+		<-ticker.C
+	}
+}
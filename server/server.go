@@ -3,17 +3,20 @@ package server
 import (
 	"context"
 	"crypto/tls"
+	"errors"
 	"fmt"
 	"github.com/apernet/quic-go"
+	"github.com/apernet/quic-go/logging"
 	"github.com/apernet/quic-go/qlog"
 	"html/template"
 	"net"
 	"net/http"
 	"os"
 	"qperf-go/common"
-	"qperf-go/internal/congestion"
+	"qperf-go/internal/congestion/brutal"
 	"qperf-go/internal/congestion/rl"
-	"strings"
+	"qperf-go/internal/netem"
+	"sync"
 	"time"
 
 	"github.com/apernet/quic-go/http3"
@@ -22,32 +25,34 @@ import (
 
 // Run server.
 // if proxyAddr is nil, no proxy is used.
-func Run(addr net.UDPAddr, createQLog bool, migrateAfter time.Duration, tlsServerCertFile string, tlsServerKeyFile string, initialCongestionWindow uint32, minCongestionWindow uint32, maxCongestionWindow uint32, initialReceiveWindow uint64, maxReceiveWindow uint64, noXse bool, logPrefix string, qlogPrefix string, http3enabled bool, www string, redisAddr string, cc string) {
+// if allow0RTT is false, 0-RTT is rejected.
+// rlTransportURI is the transport to the agent of the rl cc, see rl.NewTransport.
+// rlActionSpace, rlActionValues, rlMinCwnd and rlMaxCwnd define the actions of the rl agent, see rl.NewActionSpace.
+// rlStepInterval, rlStepTimeout and rlStepDefaultAction configure the synchronous step mode, see rl.NewStepConfig.
+// rlRewardWeights are the throughput, delay and loss weights of the reward, see rl.Reward.
+// rlEpisodeLogFile is the JSONL file the rl steps of all connections are written to, empty for none.
+// rlPolicyFile or rlReplayFile is the policy of the rl-static cc, see rl.LoadPolicy and rl.LoadReplay.
+// if netemConf is not nil, the link is emulated for the packets sent by the server.
+// brutalRate is the sending rate of brutal in bytes per second, if the client does not announce its receive bandwidth.
+func Run(addr net.UDPAddr, createQLog bool, migrateAfter time.Duration, tlsServerCertFile string, tlsServerKeyFile string, initialCongestionWindow uint32, minCongestionWindow uint32, maxCongestionWindow uint32, initialReceiveWindow uint64, maxReceiveWindow uint64, noXse bool, allow0RTT bool, logPrefix string, qlogPrefix string, http3enabled bool, www string, rlTransportURI string, cc string, brutalRate uint64, brutalMinAckRate float64, brutalCongestionWindowMultiplier float64, rlActionSpace string, rlActionValues []float64, rlMinCwnd uint64, rlMaxCwnd uint64, rlStepInterval float64, rlStepTimeout time.Duration, rlStepDefaultAction string, rlRewardWeights [3]float64, rlEpisodeLogFile string, rlPolicyFile string, rlReplayFile string, rlReplayConnectionID string, netemConf *netem.Config) {
 
 	logger := common.DefaultLogger.WithPrefix(logPrefix)
 
 	// tracers := make([]logging.Tracer, 0)
 
-	tracer := qlog.DefaultTracer
-	if !createQLog {
-		// tracers = append(tracers, common.NewQlogTracer(qlogPrefix, c.logger))
-		tracer = nil
+	// ECN-CE marks are counted for the observations of the rl cc
+	ecnCounters := &common.ECNCounters{}
+	events := newEventTracer(logger)
+	tracer := func(ctx context.Context, p logging.Perspective, connID logging.ConnectionID) *logging.ConnectionTracer {
+		tracers := []*logging.ConnectionTracer{ecnCounters.NewConnectionTracer(ctx, p, connID), events.NewConnectionTracer(ctx, p, connID)}
+		if createQLog {
+			tracers = append(tracers, qlog.DefaultTracer(ctx, p, connID))
+		}
+		return common.NewMultiplexedConnectionTracer(tracers...)
 	}
 
 	// TODO somehow associate it with the qperf session for logging
 
-	// tracers = append(tracers, common.NewEventTracer(common.Handlers{
-	// 	UpdatePath: func(odcid logging.ConnectionID, newRemote net.Addr) {
-	// 		logger.Infof("migrated QUIC connection %s to %s", odcid.String(), newRemote)
-	// 	},
-	// 	StartedConnection: func(odcid logging.ConnectionID, local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
-	// 		logger.Infof("started QUIC connection %s", odcid.String())
-	// 	},
-	// 	ClosedConnection: func(odcid logging.ConnectionID, err error) {
-	// 		logger.Infof("closed QUIC connection %s", odcid.String())
-	// 	},
-	// }))
-
 	if initialReceiveWindow > maxReceiveWindow {
 		maxReceiveWindow = initialReceiveWindow
 	}
@@ -64,6 +69,8 @@ func Run(addr net.UDPAddr, createQLog bool, migrateAfter time.Duration, tlsServe
 		// MaxCongestionWindow:            maxCongestionWindow,
 		InitialStreamReceiveWindow: initialReceiveWindow,
 		MaxStreamReceiveWindow:     maxReceiveWindow,
+		MaxIncomingStreams:         maxIncomingStreams,
+		Allow0RTT:                  allow0RTT,
 		// InitialConnectionReceiveWindow: uint64(float64(initialReceiveWindow) * quic.ConnectionFlowControlMultiplier),
 		// MaxConnectionReceiveWindow:     uint64(float64(maxReceiveWindow) * quic.ConnectionFlowControlMultiplier),
 		// TODO add option to disable mtu discovery
@@ -97,61 +104,104 @@ func Run(addr net.UDPAddr, createQLog bool, migrateAfter time.Duration, tlsServe
 			QuicConfig: &conf,
 			TLSConfig:  &tlsConf,
 		}
-		err = server.ListenAndServe()
+		if netemConf != nil {
+			err = server.Serve(listenNetem(logger, addr, netemConf))
+		} else {
+			err = server.ListenAndServe()
+		}
 		if err != nil {
 			panic(err)
 		}
 		return
 	}
 
-	listener, err := quic.ListenAddrEarly(addr.String(), &tlsConf, &conf)
+	var conn net.PacketConn
+	if netemConf != nil {
+		conn = listenNetem(logger, addr, netemConf)
+	} else {
+		conn, err = net.ListenUDP("udp", &addr)
+		if err != nil {
+			panic(err)
+		}
+	}
+	listener, err := common.ListenEarly(conn, &tlsConf, &conf)
 	if err != nil {
 		panic(err)
 	}
 
-	// print new reno as this is the only option in quic-go
-	logger.Infof("starting server with pid %d, port %d, cc new reno", os.Getpid(), addr.Port)
+	if !isSupportedCongestionControl(cc) {
+		panic("invalid cc:" + cc)
+	}
+	actionSpace, err := rl.NewActionSpace(rlActionSpace, rlActionValues, rlMinCwnd, rlMaxCwnd)
+	if err != nil {
+		panic(err)
+	}
+	stepConfig, err := rl.NewStepConfig(actionSpace, rlStepInterval, rlStepTimeout, rlStepDefaultAction)
+	if err != nil {
+		panic(err)
+	}
+	reward, err := rl.NewReward(rlRewardWeights[0], rlRewardWeights[1], rlRewardWeights[2])
+	if err != nil {
+		panic(err)
+	}
+	var newPolicy func() rl.Policy
+	switch {
+	case rlPolicyFile != "" && rlReplayFile != "":
+		panic("rl policy and rl replay are mutually exclusive")
+	case rlPolicyFile != "":
+		newPolicy, err = rl.LoadPolicy(rlPolicyFile)
+	case rlReplayFile != "":
+		newPolicy, err = rl.LoadReplay(rlReplayFile, rlReplayConnectionID)
+	case cc == common.CC_RL_STATIC:
+		err = errors.New("the rl-static cc needs a rl policy or replay")
+	}
+	if err != nil {
+		panic(err)
+	}
+	var episodeLog *rl.EpisodeLog
+	if rlEpisodeLogFile != "" {
+		episodeLog, err = rl.NewEpisodeLog(rlEpisodeLogFile)
+		if err != nil {
+			panic(err)
+		}
+		logger.Infof("writing rl episodes to %s", rlEpisodeLogFile)
+	}
+	logger.Infof("starting server with pid %d, port %d, default cc %s", os.Getpid(), addr.Port, cc)
 
-	// migrate
-	// if migrateAfter.Nanoseconds() != 0 {
-	// 	go func() {
-	// 		time.Sleep(migrateAfter)
-	// 		addr, err := listener.MigrateUDPSocket()
-	// 		if err != nil {
-	// 			panic(err)
-	// 		}
-	// 		logger.Infof("migrated to %s", addr.String())
-	// 	}()
-	// }
+	// clients can migrate, the server follows their new address, but QUIC has no migration of the server address
+	if migrateAfter != 0 {
+		logger.Infof("migrating the server is not supported, ignoring migrate")
+	}
 
 	var nextConnectionId uint64 = 0
-	redisAddrSplits := strings.Split(redisAddr, ":")
-	redisConf := rl.RedisConf{
-		Host: redisAddrSplits[0],
-		Port: redisAddrSplits[1],
+	rlTransport := &lazyRLTransport{uri: rlTransportURI}
+	policyTransport := rl.NewChannelTransport()
+	brutalConf := brutal.Config{
+		Bps:                        brutalRate,
+		MinAckRate:                 brutalMinAckRate,
+		CongestionWindowMultiplier: brutalCongestionWindowMultiplier,
 	}
 	for {
 		quicConnection, err := listener.Accept(context.Background())
 		if err != nil {
 			panic(err)
 		}
-
-		// cc
-		switch cc {
-		case common.CC_CUBIC:
-		case common.CC_RL:
-			congestion.UseRL(quicConnection, &redisConf)
-		case common.CC_BRUTAL:
-			congestion.UseBrutal(quicConnection, uint64(5*1024*1024))
-		default:
-			panic("invalid cc:" + cc)
-		}
-		logger.Infof("using %s cc", cc)
+		events.Watch(quicConnection)
 
 		qperfSession := &qperfServerSession{
-			connection:   quicConnection,
-			connectionID: nextConnectionId,
-			logger:       logger.WithPrefix(fmt.Sprintf("connection %d", nextConnectionId)),
+			connection:      quicConnection,
+			connectionID:    nextConnectionId,
+			logger:          logger.WithPrefix(fmt.Sprintf("connection %d", nextConnectionId)),
+			cc:              cc,
+			rlTransport:     rlTransport,
+			actionSpace:     actionSpace,
+			stepConfig:      stepConfig,
+			reward:          reward,
+			episodeLog:      episodeLog,
+			newPolicy:       newPolicy,
+			policyTransport: policyTransport,
+			brutalConf:      &brutalConf,
+			ecnCounters:     ecnCounters,
 		}
 
 		go qperfSession.run()
@@ -159,6 +209,42 @@ func Run(addr net.UDPAddr, createQLog bool, migrateAfter time.Duration, tlsServe
 	}
 }
 
+// newEventTracer logs the events of the connections relative to their start.
+func newEventTracer(logger common.Logger) *common.EventTracer {
+	// states of the connections by original destination connection ID
+	var states sync.Map
+	handlers := common.LogEvents(logger, func(odcid logging.ConnectionID) time.Duration {
+		state, ok := states.Load(odcid)
+		if !ok {
+			return 0
+		}
+		return time.Since(state.(*common.State).StartTime())
+	})
+	startedConnection := handlers.StartedConnection
+	handlers.StartedConnection = func(odcid logging.ConnectionID, local, remote net.Addr, srcConnID, destConnID logging.ConnectionID) {
+		state := &common.State{}
+		state.SetStartTime()
+		states.Store(odcid, state)
+		startedConnection(odcid, local, remote, srcConnID, destConnID)
+	}
+	closedConnection := handlers.ClosedConnection
+	handlers.ClosedConnection = func(odcid logging.ConnectionID, err error) {
+		closedConnection(odcid, err)
+		states.Delete(odcid)
+	}
+	return common.NewEventTracer(handlers)
+}
+
+// listenNetem opens the UDP socket of the server with an emulated link.
+func listenNetem(logger common.Logger, addr net.UDPAddr, netemConf *netem.Config) net.PacketConn {
+	udpConn, err := net.ListenUDP("udp", &addr)
+	if err != nil {
+		panic(err)
+	}
+	logger.Infof("emulating link: %s", netemConf)
+	return netem.NewPacketConn(udpConn, netemConf)
+}
+
 // See https://en.wikipedia.org/wiki/Lehmer_random_number_generator
 func generatePRData(l int) []byte {
 	res := make([]byte, l)